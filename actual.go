@@ -1,15 +1,12 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
 	"math"
-	"net/http"
-	"net/url"
-	. "nw-updater/common"
+	"nw-updater/actual"
 	"nw-updater/crypto"
+	"strings"
 	"time"
 )
 
@@ -18,176 +15,164 @@ type ActualBudgetConfig struct {
 	EncryptedApiKey string `yaml:"encrypted_api_key"`
 	ApiUrl          string `yaml:"api_url"`
 	SyncId          string `yaml:"sync_id"`
+	// The payee, category, cleared status and memo of adjustment transactions
+	Transactions *TransactionConfig `yaml:"transactions,omitempty"`
 }
 
 // ActualBudget is used to interact with the Actual Budget Http api.
 type ActualBudget struct {
-	ApiKey string
+	client *actual.Client
 	ActualBudgetConfig
 }
 
-// ABAccounts holds the JSON response from the Actual Budget accounts endpoint
-type ABAccounts struct {
-	Data []ABAccount `json:"data"`
+func NewActualBudget(config ActualBudgetConfig, decryptor crypto.OpenSslDecryptor) ActualBudget {
+	return ActualBudget{
+		client:             actual.NewClient(config.ApiUrl, decryptor.Decrypt(config.EncryptedApiKey), config.SyncId),
+		ActualBudgetConfig: config,
+	}
 }
 
-// ABAccount is the JSON representation of an Actual Budget account
-type ABAccount struct {
-	Id        string `json:"id"`
-	Name      string `json:"name"`
-	OffBudget bool   `json:"offbudget"`
-	Closed    bool   `json:"closed"`
+func init() {
+	registerDestination("actual", func(config Config, d crypto.OpenSslDecryptor) (Destination, error) {
+		if config.ActualConfig == nil {
+			return nil, nil
+		}
+		err := config.ActualConfig.Transactions.Validate()
+		if err != nil {
+			return nil, err
+		}
+		return NewActualBudget(*config.ActualConfig, d), nil
+	})
 }
 
-// ABBalance holds the JSON response from the Actual Budget account balance endpoint
-type ABBalance struct {
-	Data int64 `json:"data"`
+// Name returns the name of Actual Budget as a Destination.
+func (a ActualBudget) Name() string {
+	return "Actual Budget"
 }
 
-// ABTransactionRequest is the JSON request body for creating a transaction in Actual Budget
-type ABTransactionRequest struct {
-	LearnCategories bool          `json:"learnCategories"`
-	RunTransfers    bool          `json:"runTransfers"`
-	Transaction     ABTransaction `json:"transaction"`
+// ListAccounts returns all accounts in the budget as destination accounts.
+func (a ActualBudget) ListAccounts() ([]DestinationAccount, error) {
+	accounts, err := a.GetAccounts()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]DestinationAccount, len(accounts))
+	for i, account := range accounts {
+		result[i] = DestinationAccount{Id: account.Id, Name: account.Name, Closed: account.Closed,
+			OnBudget: !account.OffBudget}
+	}
+	return result, nil
 }
 
-// ABTransaction is the JSON representation of a transaction in Actual Budget
-type ABTransaction struct {
-	Account   string `json:"account"`
-	Category  string `json:"category"`
-	Amount    int64  `json:"amount"`
-	PayeeName string `json:"payee_name"`
-	Date      string `json:"date"`
-	Cleared   bool   `json:"cleared"`
-	Notes     string `json:"notes"`
+// GetBalance gets the balance of an Actual account in cents as of the end of date.
+func (a ActualBudget) GetBalance(account DestinationAccount, date time.Time) (int64, error) {
+	return a.client.Balance(context.Background(), account.Id, date.Format(time.DateOnly))
 }
 
-func NewActualBudget(config ActualBudgetConfig, decryptor crypto.OpenSslDecryptor) ActualBudget {
-	return ActualBudget{
-		ApiKey:             decryptor.Decrypt(config.EncryptedApiKey),
-		ActualBudgetConfig: config,
+// CreateAdjustment creates an adjustment transaction in an Actual account so that its balance matches
+// the new balance as of the adjustment date. In idempotent mode, an adjustment already created on the same
+// account and date is updated instead, or deleted if the amounts cancel out.
+func (a ActualBudget) CreateAdjustment(adjustment Adjustment) error {
+	ctx := context.Background()
+	transactionDate := adjustment.Date.Format(time.DateOnly)
+	var existing *actual.Transaction
+	var err error
+	if a.Transactions.IsIdempotent() {
+		existing, err = a.findAdjustment(ctx, adjustment.AccountId, transactionDate)
+		if err != nil {
+			return err
+		}
 	}
-}
-
-// UpdateBalances takes a map of Actual account ids to SimpleFin account structs and creates
-// adjustment transactions in those accounts to make the account balances match.
-func (a ActualBudget) UpdateBalances(balances map[string]AccountBalance) error {
-	fmt.Println("Getting accounts from Actual Budget...")
-	accounts, err := a.GetAccounts()
+	if existing != nil {
+		// the transaction replaces the earlier one, so its notes describe both changes
+		adjustment = adjustment.Replacing(existing.Amount * 10)
+	}
+	t, err := a.Transactions.Transaction(adjustment)
 	if err != nil {
 		return err
 	}
-	// update balances
-	fmt.Println("Getting and updating balances in Actual Budget...")
-	for _, account := range accounts {
-		if balance, ok := balances[account.Name]; ok {
-			err = a.updateBalance(account, balance)
-			if err != nil {
-				return err
-			}
-		}
-	}
-	return nil
-}
-
-// updateBalance updates the balance of an Actual account to match the provided SimpleFin account balance
-// as of the balance date.
-func (a ActualBudget) updateBalance(account ABAccount, balance AccountBalance) error {
-	balanceUrl, err := url.JoinPath(a.ApiUrl, "budgets", a.SyncId, "accounts", account.Id, "balance")
+	categoryId, err := a.categoryId(t.Category)
 	if err != nil {
 		return err
 	}
-	req, err := http.NewRequest("GET", balanceUrl, nil)
-	if err != nil {
-		return fmt.Errorf("error creating balance request: %w", err)
+	amount := adjustment.Difference()
+	transaction := actual.Transaction{
+		Account:   adjustment.AccountId,
+		Category:  categoryId,
+		Amount:    amount,
+		PayeeName: t.Payee,
+		Date:      transactionDate,
+		Cleared:   t.Cleared,
+		Notes:     t.Memo,
+	}
+	if a.Transactions.IsIdempotent() {
+		transaction.Notes = strings.TrimSpace(transaction.Notes + " " + adjustmentMarker)
+	}
+	action := "Updated"
+	switch {
+	case existing == nil:
+		err = a.client.CreateTransaction(ctx, transaction)
+		if err != nil {
+			return fmt.Errorf("error creating transaction: %w", err)
+		}
+	case amount == 0:
+		err = a.client.DeleteTransaction(ctx, existing.Id)
+		if err != nil {
+			return fmt.Errorf("error deleting transaction: %w", err)
+		}
+		action = "Deleted today's adjustment, updated"
+	default:
+		transaction.Id = existing.Id
+		err = a.client.UpdateTransaction(ctx, transaction)
+		if err != nil {
+			return fmt.Errorf("error updating transaction: %w", err)
+		}
+		action = "Changed today's adjustment, updated"
 	}
-	req.Header.Set("x-api-key", a.ApiKey)
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("error getting balance for account '%s': %w", account.Name, err)
+	sign := "+"
+	if amount < 0 {
+		sign = "-"
 	}
-	defer resp.Body.Close()
-	balanceJson := new(ABBalance)
-	reader := resp.Body.(io.Reader)
-	decoder := json.NewDecoder(reader)
-	err = decoder.Decode(balanceJson)
-	if err != nil {
-		return fmt.Errorf("error decoding balance response: %w", err)
-	}
-	newBalance := balance.Balance
-	if balanceJson.Data == newBalance {
-		fmt.Printf("Account balance has not changed for '%s': ($%.2f)\n", account.Name, float64(newBalance)/100.0)
-		return nil
-	}
-	// balances differ
-	payee := "Market Changes"
-	memo := "Entered automatically by nw-updater"
-	difference := newBalance - balanceJson.Data
-	transactionDate := balance.BalanceDate.Format(time.DateOnly)
+	fmt.Printf("%s '%s' to $%.2f as of %s ($%s%.2f)\n", action,
+		adjustment.AccountName, float64(adjustment.New)/100.0, transactionDate, sign, math.Abs(float64(amount))/100.0)
+	return nil
+}
 
-	transaction := ABTransactionRequest{
-		LearnCategories: false,
-		RunTransfers:    false,
-		Transaction: ABTransaction{
-			Account:   account.Id,
-			Amount:    difference,
-			PayeeName: payee,
-			Date:      transactionDate,
-			Cleared:   true,
-			Notes:     memo,
-		},
-	}
-	buffer := new(bytes.Buffer)
-	err = json.NewEncoder(buffer).Encode(transaction)
+// findAdjustment returns the adjustment created in idempotent mode on an account and date, or nil if there isn't one.
+func (a ActualBudget) findAdjustment(ctx context.Context, accountId, date string) (*actual.Transaction, error) {
+	transactions, err := a.client.Transactions(ctx, accountId, date, date)
 	if err != nil {
-		return fmt.Errorf("error encoding transaction: %w", err)
+		return nil, fmt.Errorf("error getting transactions: %w", err)
 	}
-	transactionUrl, err := url.JoinPath(a.ApiUrl, "budgets", a.SyncId, "accounts", account.Id, "transactions")
-	if err != nil {
-		return err
+	for _, t := range transactions {
+		if t.Date == date && strings.Contains(t.Notes, adjustmentMarker) {
+			return &t, nil
+		}
 	}
+	return nil, nil
+}
 
-	req, err = http.NewRequest("POST", transactionUrl, buffer)
-	if err != nil {
-		return fmt.Errorf("error creating transaction request: %w", err)
+// categoryId returns the id of the category with the id or name key, or "" if key is empty.
+func (a ActualBudget) categoryId(key string) (string, error) {
+	if key == "" {
+		return "", nil
 	}
-	req.Header.Set("x-api-key", a.ApiKey)
-	req.Header.Set("Content-Type", "application/json")
-	resp, err = http.DefaultClient.Do(req)
+	categories, err := a.client.Categories(context.Background())
 	if err != nil {
-		return fmt.Errorf("error creating transaction: %w", err)
+		return "", fmt.Errorf("error getting categories: %w", err)
 	}
-	defer resp.Body.Close()
-	sign := "+"
-	if difference < 0 {
-		sign = "-"
+	result := make([]category, len(categories))
+	for i, c := range categories {
+		result[i] = category{id: c.Id, name: c.Name}
 	}
-	fmt.Printf("Updated '%s' to $%.2f as of %s ($%s%.2f)\n",
-		account.Name, float64(newBalance)/100.0, transactionDate, sign, math.Abs(float64(difference))/100.0)
-	return nil
+	return findCategory(result, key)
 }
 
 // GetAccounts returns a list of all accounts in the budget.
-func (a ActualBudget) GetAccounts() ([]ABAccount, error) {
-	// get accounts
-	accountsUrl, err := url.JoinPath(a.ApiUrl, "budgets", a.SyncId, "accounts")
-	if err != nil {
-		return nil, err
-	}
-	req, err := http.NewRequest("GET", accountsUrl, nil)
-	if err != nil {
-		return nil, fmt.Errorf("error making account request: %w", err)
-	}
-	req.Header.Set("x-api-key", a.ApiKey)
-	req.Header.Set("Accept", "application/json")
-	resp, err := http.DefaultClient.Do(req)
+func (a ActualBudget) GetAccounts() ([]actual.Account, error) {
+	accounts, err := a.client.Accounts(context.Background())
 	if err != nil {
 		return nil, fmt.Errorf("error getting accounts: %w", err)
 	}
-	defer resp.Body.Close()
-	accounts := new(ABAccounts)
-	reader := resp.Body.(io.Reader)
-	decoder := json.NewDecoder(reader)
-	err = decoder.Decode(accounts)
-	return accounts.Data, nil
+	return accounts, nil
 }
@@ -0,0 +1,251 @@
+/*
+Package actual is a client for the [actual-http-api] wrapper around the Actual Budget API.
+
+Responses with an error status are returned as an [*APIError] with the message from actual-http-api.
+Network errors and server errors are retried with exponential backoff. Requests that create something are only
+retried if the connection couldn't be made, since a server error or lost response may mean it was created anyway.
+
+[actual-http-api]: https://github.com/jhonderson/actual-http-api
+*/
+package actual
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Account is an Actual Budget account.
+type Account struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	OffBudget bool   `json:"offbudget"`
+	Closed    bool   `json:"closed"`
+}
+
+// Transaction is an Actual Budget transaction. Amount is in cents.
+type Transaction struct {
+	Id        string `json:"id,omitempty"`
+	Account   string `json:"account"`
+	Category  string `json:"category,omitempty"`
+	Amount    int64  `json:"amount"`
+	PayeeName string `json:"payee_name"`
+	Date      string `json:"date"`
+	Cleared   bool   `json:"cleared"`
+	Notes     string `json:"notes"`
+}
+
+// Category is an Actual Budget category.
+type Category struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// transactionRequest is the request body for creating a transaction.
+type transactionRequest struct {
+	LearnCategories bool        `json:"learnCategories"`
+	RunTransfers    bool        `json:"runTransfers"`
+	Transaction     Transaction `json:"transaction"`
+}
+
+// response is the envelope actual-http-api wraps results in.
+type response[T any] struct {
+	Data T `json:"data"`
+}
+
+// APIError is returned when actual-http-api responds with an error status.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string // The error message from actual-http-api, or the start of the body if it isn't JSON
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s: %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Temporary returns true for errors that may succeed if the request is retried.
+func (e *APIError) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// Client makes requests to actual-http-api for one budget.
+type Client struct {
+	BaseUrl    string
+	ApiKey     string
+	SyncId     string
+	HttpClient *http.Client
+	Retries    int           // The number of times to retry network and server errors, see retryable
+	Backoff    time.Duration // The delay before the first retry, doubling each time
+}
+
+// NewClient creates a Client for the budget with syncId, with the default retries.
+func NewClient(baseUrl, apiKey, syncId string) *Client {
+	return &Client{
+		BaseUrl:    baseUrl,
+		ApiKey:     apiKey,
+		SyncId:     syncId,
+		HttpClient: &http.Client{Timeout: 30 * time.Second},
+		Retries:    3,
+		Backoff:    1 * time.Second,
+	}
+}
+
+// Accounts returns all accounts in the budget.
+func (c *Client) Accounts(ctx context.Context) ([]Account, error) {
+	var resp response[[]Account]
+	err := c.do(ctx, http.MethodGet, nil, nil, &resp, "accounts")
+	return resp.Data, err
+}
+
+// Balance returns the balance of an account in cents, including transactions up to cutoffDate, YYYY-MM-DD,
+// or all transactions if it is empty.
+func (c *Client) Balance(ctx context.Context, accountId, cutoffDate string) (int64, error) {
+	var resp response[int64]
+	var query url.Values
+	if cutoffDate != "" {
+		query = url.Values{"cutoff_date": {cutoffDate}}
+	}
+	err := c.do(ctx, http.MethodGet, query, nil, &resp, "accounts", accountId, "balance")
+	return resp.Data, err
+}
+
+// Categories returns all categories in the budget.
+func (c *Client) Categories(ctx context.Context) ([]Category, error) {
+	var resp response[[]Category]
+	err := c.do(ctx, http.MethodGet, nil, nil, &resp, "categories")
+	return resp.Data, err
+}
+
+// CreateTransaction adds a transaction to an account, without running rules or learning categories.
+func (c *Client) CreateTransaction(ctx context.Context, t Transaction) error {
+	return c.do(ctx, http.MethodPost, nil, transactionRequest{Transaction: t}, nil, "accounts", t.Account,
+		"transactions")
+}
+
+// Transactions returns the transactions in an account dated from since to until, both YYYY-MM-DD.
+func (c *Client) Transactions(ctx context.Context, accountId, since, until string) ([]Transaction, error) {
+	var resp response[[]Transaction]
+	query := url.Values{"since_date": {since}, "until_date": {until}}
+	err := c.do(ctx, http.MethodGet, query, nil, &resp, "accounts", accountId, "transactions")
+	return resp.Data, err
+}
+
+// UpdateTransaction replaces the fields of the transaction with t.Id.
+func (c *Client) UpdateTransaction(ctx context.Context, t Transaction) error {
+	return c.do(ctx, http.MethodPatch, nil, transactionRequest{Transaction: t}, nil, "transactions", t.Id)
+}
+
+// DeleteTransaction deletes a transaction.
+func (c *Client) DeleteTransaction(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, nil, nil, nil, "transactions", id)
+}
+
+// do makes a request to the budget's path with query if it isn't nil, encoding body as JSON if it isn't nil,
+// and decoding the response into result if it isn't nil. Errors are retried if they are retryable.
+func (c *Client) do(ctx context.Context, method string, query url.Values, body, result any, path ...string) error {
+	requestUrl, err := url.JoinPath(c.BaseUrl, append([]string{"budgets", c.SyncId}, path...)...)
+	if err != nil {
+		return err
+	}
+	if query != nil {
+		requestUrl += "?" + query.Encode()
+	}
+	var encoded []byte
+	if body != nil {
+		encoded, err = json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("error encoding request: %w", err)
+		}
+	}
+	delay := c.Backoff
+	for attempt := 0; ; attempt++ {
+		err = c.try(ctx, method, requestUrl, encoded, result)
+		if err == nil || !retryable(method, err) || attempt >= c.Retries || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// retryable returns true if a request that failed with err can be sent again without changing the budget twice.
+// GET, PATCH and DELETE are idempotent, so network errors and temporary API errors are retried. Other methods
+// like POST are only retried if the connection failed, since otherwise the request may have been applied.
+func retryable(method string, err error) bool {
+	switch method {
+	case http.MethodGet, http.MethodPatch, http.MethodDelete:
+		if apiErr, ok := errors.AsType[*APIError](err); ok {
+			return apiErr.Temporary()
+		}
+		return true
+	default:
+		opErr, ok := errors.AsType[*net.OpError](err)
+		return ok && opErr.Op == "dial"
+	}
+}
+
+// try makes a single request.
+func (c *Client) try(ctx context.Context, method, requestUrl string, body []byte, result any) error {
+	req, err := http.NewRequestWithContext(ctx, method, requestUrl, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("x-api-key", c.ApiKey)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.HttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return newAPIError(req, resp)
+	}
+	if result == nil {
+		return nil
+	}
+	err = json.NewDecoder(resp.Body).Decode(result)
+	if err != nil {
+		return fmt.Errorf("error decoding response from %s %s: %w", method, req.URL.Path, err)
+	}
+	return nil
+}
+
+// newAPIError reads the error message from an error response. actual-http-api responds with
+// {"error": "message"}, but proxies in front of it may not.
+func newAPIError(req *http.Request, resp *http.Response) *APIError {
+	apiErr := &APIError{Method: req.Method, Path: req.URL.Path, StatusCode: resp.StatusCode}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var parsed struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &parsed) == nil && (parsed.Error != "" || parsed.Message != "") {
+		apiErr.Message = parsed.Error
+		if apiErr.Message == "" {
+			apiErr.Message = parsed.Message
+		}
+	} else {
+		apiErr.Message = strings.TrimSpace(string(body))
+		if len(apiErr.Message) > 200 {
+			apiErr.Message = apiErr.Message[:200] + "..."
+		}
+	}
+	return apiErr
+}
@@ -0,0 +1,175 @@
+package actual
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a Client for a server that responds with handler, retrying quickly, and a count of the
+// requests the server received.
+func newTestClient(t *testing.T, handler http.HandlerFunc) (*Client, *atomic.Int32) {
+	requests := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+	c := NewClient(server.URL, "key", "budget")
+	c.Backoff = time.Millisecond
+	return c, requests
+}
+
+func TestAccounts(t *testing.T) {
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/budgets/budget/accounts" || r.Header.Get("x-api-key") != "key" {
+			http.Error(w, `{"error": "wrong request"}`, http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data": [{"id": "a1", "name": "Brokerage", "offbudget": true}]}`))
+	})
+	accounts, err := c.Accounts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 1 || accounts[0] != (Account{Id: "a1", Name: "Brokerage", OffBudget: true}) {
+		t.Errorf("got accounts %v", accounts)
+	}
+}
+
+func TestBalanceCutoffDate(t *testing.T) {
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/budgets/budget/accounts/a1/balance" || r.URL.Query().Get("cutoff_date") != "2026-01-31" {
+			http.Error(w, `{"error": "wrong request"}`, http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data": 123456}`))
+	})
+	balance, err := c.Balance(context.Background(), "a1", "2026-01-31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 123456 {
+		t.Errorf("got balance %d, expected 123456", balance)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	tests := []struct {
+		status    int
+		body      string
+		message   string
+		temporary bool
+	}{
+		{status: http.StatusNotFound, body: `{"error": "Account not found"}`, message: "Account not found"},
+		{status: http.StatusBadRequest, body: `{"message": "Invalid date"}`, message: "Invalid date"},
+		{status: http.StatusUnauthorized, body: "Unauthorized\n", message: "Unauthorized"},
+		{status: http.StatusBadGateway, body: "<html>" + strings.Repeat("x", 300), temporary: true,
+			message: "<html>" + strings.Repeat("x", 194) + "..."},
+		{status: http.StatusTooManyRequests, body: `{"error": "slow down"}`, message: "slow down", temporary: true},
+	}
+	for _, test := range tests {
+		c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+			_, _ = w.Write([]byte(test.body))
+		})
+		c.Retries = 0
+		_, err := c.Accounts(context.Background())
+		apiErr, ok := errors.AsType[*APIError](err)
+		if !ok {
+			t.Errorf("status %d returned %v, expected an APIError", test.status, err)
+			continue
+		}
+		if apiErr.StatusCode != test.status || apiErr.Message != test.message || apiErr.Method != http.MethodGet ||
+			apiErr.Path != "/budgets/budget/accounts" {
+			t.Errorf("status %d returned %#v", test.status, apiErr)
+		}
+		if apiErr.Temporary() != test.temporary {
+			t.Errorf("status %d Temporary() = %t, expected %t", test.status, apiErr.Temporary(), test.temporary)
+		}
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int32 // requests that fail before one succeeds
+		status   int   // the status of failed requests, or 0 to close the connection without a response
+		create   bool  // make a POST instead of a GET
+		requests int32
+		wantErr  bool
+	}{
+		{name: "server error then success", failures: 2, status: http.StatusServiceUnavailable, requests: 3},
+		{name: "too many requests", failures: 1, status: http.StatusTooManyRequests, requests: 2},
+		{name: "gives up after retries", failures: 10, status: http.StatusInternalServerError, requests: 4,
+			wantErr: true},
+		{name: "client error isn't retried", failures: 1, status: http.StatusBadRequest, requests: 1, wantErr: true},
+		{name: "lost response is retried", failures: 1, requests: 2},
+		{name: "create isn't retried after server error", failures: 1, status: http.StatusInternalServerError,
+			create: true, requests: 1, wantErr: true},
+		{name: "create isn't retried after lost response", failures: 1, create: true, requests: 1, wantErr: true},
+	}
+	for _, test := range tests {
+		var c *Client
+		var requests *atomic.Int32
+		c, requests = newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			if requests.Load() <= test.failures {
+				if test.status == 0 {
+					panic(http.ErrAbortHandler)
+				}
+				http.Error(w, `{"error": "failed"}`, test.status)
+				return
+			}
+			_, _ = w.Write([]byte(`{"data": []}`))
+		})
+		var err error
+		if test.create {
+			err = c.CreateTransaction(context.Background(), Transaction{Account: "a1", Amount: 100})
+		} else {
+			_, err = c.Accounts(context.Background())
+		}
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: returned error %v", test.name, err)
+		}
+		if got := requests.Load(); got != test.requests {
+			t.Errorf("%s: made %d requests, expected %d", test.name, got, test.requests)
+		}
+	}
+}
+
+func TestCreateRetriedWhenConnectionFails(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	c := NewClient(server.URL, "key", "budget")
+	c.Retries = 0
+	err := c.CreateTransaction(context.Background(), Transaction{Account: "a1", Amount: 100})
+	if err == nil {
+		t.Fatal("expected a connection error")
+	}
+	if !retryable(http.MethodPost, err) {
+		t.Errorf("POST that couldn't connect isn't retryable: %v", err)
+	}
+}
+
+func TestRetryStopsWhenCancelled(t *testing.T) {
+	c, requests := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error": "failed"}`, http.StatusServiceUnavailable)
+	})
+	c.Backoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.Accounts(ctx)
+	if _, ok := errors.AsType[*APIError](err); !ok {
+		t.Errorf("returned %v, expected the last APIError", err)
+	}
+	if requests.Load() != 1 || time.Since(start) > 10*time.Second {
+		t.Errorf("made %d requests in %s, expected to stop waiting when cancelled", requests.Load(),
+			time.Since(start))
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	. "nw-updater/common"
+
+	"nw-updater/crypto"
+	"nw-updater/institution"
+)
+
+// CaptureMain logs in to an institution from the config and saves sanitized copies of the pages it uses,
+// so they can be replayed by the institution tests.
+func CaptureMain(args []string, ctx context.Context, config Config, decryptor crypto.OpenSslDecryptor,
+	newBrowser BrowserFactory) error {
+
+	fs := flag.NewFlagSet("nw-updater capture", flag.ExitOnError)
+	instString := fs.String("institution", "", "The institution to capture pages from")
+	username := fs.String("username", "", "The username to use in the config file. Optional if there is only one for this institution.")
+	dir := fs.String("dir", "", "Directory to save the pages in")
+	_ = fs.Parse(args)
+	if *dir == "" {
+		return errors.New("--dir is required")
+	}
+	ic, err := config.FindInstitution(*instString, *username)
+	if err != nil {
+		return err
+	}
+	balances, err := GetInstitutionBalances(ctx, config, decryptor, newBrowser, ic,
+		func(ctx context.Context) context.Context {
+			return institution.WithCapture(ctx, *dir, ic.Auth.Username)
+		})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Found %d matching balances at %s for %s\n", len(balances), ic.Name, ic.Auth.Username)
+	return nil
+}
+
+// GetInstitutionBalances gets balances from one institution login, in its own browser profile if profiles_dir
+// is set, for commands that work with a single login. If a security code is needed, it is entered from the
+// terminal or remote MFA, and the balances are tried again. withContext adds options to the institution's context.
+func GetInstitutionBalances(ctx context.Context, config Config, decryptor crypto.OpenSslDecryptor,
+	newBrowser BrowserFactory, ic InstitutionConfig,
+	withContext func(context.Context) context.Context) (map[string]AccountBalance, error) {
+
+	ctx, closeBrowser, err := BrowserContext(ctx, config.ProfilesDir, newBrowser, ic)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBrowser()
+	ctx = withContext(ctx)
+	inst := institution.MustGet(ic.Name)
+	mappings := FilterMappings(config.AccountMappings, ic.Name, ic.Auth.Username)
+	balances, err := inst.GetBalances(ctx, ic.Auth, decryptor, mappings)
+	if sc, ok := inst.(institution.SecurityCode); ok && errors.Is(err, institution.ErrCodeRequired) {
+		provider, closeProvider := config.CodeProvider(decryptor, institution.TerminalCodeProvider{})
+		defer closeProvider()
+		err = institution.CompleteMfa(ctx, sc, ic.Name, ic.Auth, decryptor, provider)
+		if err == nil {
+			balances, err = inst.GetBalances(ctx, ic.Auth, decryptor, mappings)
+		}
+	}
+	return balances, err
+}
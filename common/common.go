@@ -2,9 +2,19 @@ package common
 
 import "time"
 
+// AccountBalance is the balance of an account at a source, along with where it came from.
 type AccountBalance struct {
 	Balance     int64
 	BalanceDate time.Time
 	Id          string
 	Name        string
+	Source      string // "institution" or "simplefin"
+	Institution string // The institution name, for balances from an institution login
+	Username    string // The username of the institution login
+	Currency    string // ISO 4217 code of Balance, or empty if it is in the budget's currency
+
+	// Set when Balance was converted from another currency
+	OriginalBalance  int64
+	OriginalCurrency string
+	ExchangeRate     float64
 }
@@ -35,3 +35,14 @@ func (d OpenSslDecryptor) Decrypt(s string) string {
 	}
 	return string(decoded)
 }
+
+// Seal encrypts plaintext with AES-256-GCM using the passphrase, for secrets that nw-updater writes itself.
+func (d OpenSslDecryptor) Seal(plaintext []byte) ([]byte, error) {
+	return EncryptAES256GCM(plaintext, d.passphrase)
+}
+
+// Open decrypts ciphertext produced by Seal.
+func (d OpenSslDecryptor) Open(ciphertext []byte) ([]byte, error) {
+	plaintext, err := DecryptAES256GCM(ciphertext, d.passphrase)
+	return []byte(plaintext), err
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	. "nw-updater/common"
+	"slices"
+
+	"nw-updater/institution"
+	"nw-updater/money"
+)
+
+// CurrencyConfig contains the budget's currency and where to get exchange rates for balances in other currencies.
+type CurrencyConfig struct {
+	BudgetCurrency string             `yaml:"budget_currency"`      // ISO 4217 code of the budget, defaults to USD
+	Rates          map[string]float64 `yaml:"rates,omitempty"`      // Static value of one unit of each currency in the budget currency
+	RatesFile      string             `yaml:"rates_file,omitempty"` // File with the same format as Rates
+	RatesUrl       string             `yaml:"rates_url,omitempty"`  // HTTP endpoint, see [money.HttpRates]
+}
+
+// budgetCurrency returns the configured budget currency, or USD.
+func (c *CurrencyConfig) budgetCurrency() string {
+	if c == nil || c.BudgetCurrency == "" {
+		return "USD"
+	}
+	return c.BudgetCurrency
+}
+
+// NewRateProvider creates the rate provider from the config. Static rates are used first, then the rates file,
+// then the HTTP endpoint.
+func (c *CurrencyConfig) NewRateProvider() (money.RateProvider, error) {
+	if c == nil {
+		return nil, errors.New("no currency config for exchange rates")
+	}
+	switch {
+	case len(c.Rates) > 0:
+		return money.StaticRates{Base: c.budgetCurrency(), Rates: c.Rates}, nil
+	case c.RatesFile != "":
+		return money.LoadRatesFile(c.RatesFile, c.budgetCurrency())
+	case c.RatesUrl != "":
+		return money.NewHttpRates(c.RatesUrl), nil
+	default:
+		return nil, errors.New("no exchange rates configured")
+	}
+}
+
+// ConvertBalances converts balances that are in a different currency than the budget into the budget currency,
+// recording the original balance and rate. Balances that can't be converted are left out and returned in an
+// [institution.MultiError].
+func ConvertBalances(balances map[string]AccountBalance, config *CurrencyConfig) (map[string]AccountBalance, error) {
+	target := config.budgetCurrency()
+	var provider money.RateProvider
+	converted := make(map[string]AccountBalance, len(balances))
+	errs := &institution.MultiError{}
+	for _, account := range slices.Sorted(maps.Keys(balances)) {
+		balance := balances[account]
+		if balance.Currency == "" || balance.Currency == target {
+			converted[account] = balance
+			continue
+		}
+		if provider == nil {
+			var err error
+			provider, err = config.NewRateProvider()
+			if err != nil {
+				errs.AddError(fmt.Errorf("unable to convert '%s' from %s: %w", account, balance.Currency, err))
+				continue
+			}
+		}
+		rate, err := provider.Rate(balance.Currency, target)
+		if err != nil {
+			errs.AddError(fmt.Errorf("unable to convert '%s' from %s: %w", account, balance.Currency, err))
+			continue
+		}
+		balance.OriginalBalance = balance.Balance
+		balance.OriginalCurrency = balance.Currency
+		balance.ExchangeRate = rate
+		balance.Balance = money.Convert(balance.Balance, rate)
+		balance.Currency = target
+		converted[account] = balance
+	}
+	if errs.IsEmpty() {
+		return converted, nil
+	}
+	return converted, errs
+}
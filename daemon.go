@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"time"
+
+	"nw-updater/crypto"
+	"nw-updater/schedule"
+)
+
+// DaemonConfig contains the schedules to run balance updates on in daemon mode.
+type DaemonConfig struct {
+	Schedules []ScheduleConfig `yaml:"schedules"`
+	// Address to serve the status dashboard and API on, like "127.0.0.1:8080". Disabled if empty.
+	HttpAddress string `yaml:"http_address,omitempty"`
+	// Password required with basic auth for the dashboard and API. Optional only if http_address is a loopback
+	// address, since the API can start syncs.
+	EncryptedHttpPassword string `yaml:"encrypted_http_password,omitempty"`
+}
+
+// ScheduleConfig is a cron schedule for updating balances from some or all sources.
+type ScheduleConfig struct {
+	Cron    schedule.Schedule `yaml:"cron"`              // Cron expression like "0 6 * * *" or "@hourly"
+	Sources []string          `yaml:"sources,omitempty"` // "simplefin" or institution names, all sources if empty
+}
+
+// ForSources returns a copy of the config that only gets balances from the given sources, which are "simplefin"
+// or institution names. If sources is empty, the config is returned unchanged.
+func (c Config) ForSources(sources []string) Config {
+	if len(sources) == 0 {
+		return c
+	}
+	if !slices.Contains(sources, "simplefin") {
+		c.SimpleFin = nil
+	}
+	institutions := make([]InstitutionConfig, 0, len(c.InstitutionConfig))
+	for _, ic := range c.InstitutionConfig {
+		if slices.Contains(sources, ic.Name) {
+			institutions = append(institutions, ic)
+		}
+	}
+	c.InstitutionConfig = institutions
+	return c
+}
+
+// DaemonMain keeps running, updating balances from each source on its schedule, until SIGTERM or SIGINT is received.
+// The browser from ctx is reused for every run. On shutdown, the account update in progress is finished before
+// returning. Errors from each run are emailed. If an HTTP address is configured, the status dashboard and API
+// are served on it along with Prometheus metrics, and a sync of all sources can be triggered from them.
+func DaemonMain(config Config, ctx context.Context, decryptor crypto.OpenSslDecryptor, opts RunOptions) error {
+	if config.Daemon == nil || len(config.Daemon.Schedules) == 0 {
+		return errors.New("no daemon schedules configured")
+	}
+	if config.Daemon.HttpAddress != "" && config.Daemon.EncryptedHttpPassword == "" &&
+		!isLoopback(config.Daemon.HttpAddress) {
+		return fmt.Errorf("daemon.encrypted_http_password is required to serve on %s, which isn't a loopback address",
+			config.Daemon.HttpAddress)
+	}
+	interrupt, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	// restore the default handlers after the first signal, so a second one kills a run that won't finish
+	go func() {
+		<-interrupt.Done()
+		stop()
+	}()
+	opts.Interrupt = interrupt
+	metrics := NewMetrics(config.Metrics.textfile())
+	observers := RunObservers{metrics}
+	var trigger <-chan struct{}
+	if config.Daemon.HttpAddress != "" {
+		status := NewStatusServer(decryptor.Decrypt(config.Daemon.EncryptedHttpPassword))
+		server := &http.Server{Addr: config.Daemon.HttpAddress, Handler: status.Handler(metrics)}
+		go func() {
+			fmt.Printf("Serving status on http://%s\n", config.Daemon.HttpAddress)
+			if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				fmt.Printf("Error serving status: %s\n", err)
+			}
+		}()
+		defer server.Shutdown(context.Background())
+		observers = append(observers, status)
+		trigger = status.Trigger()
+	}
+	opts.Observer = observers
+	for {
+		now := time.Now()
+		next, sources := nextRun(config.Daemon.Schedules, now)
+		if next.IsZero() {
+			return errors.New("no daemon schedules will run again")
+		}
+		fmt.Printf("Next run at %s\n", next.Format(time.RFC1123))
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-interrupt.Done():
+			timer.Stop()
+			fmt.Println("Shutting down")
+			return nil
+		case <-trigger:
+			timer.Stop()
+			fmt.Println("Sync requested")
+			sources = nil
+		case <-timer.C:
+		}
+		err := LockedRun(config.lockFile(), func() error {
+			return StandardMain(config.ForSources(sources), ctx, decryptor, opts)
+		})
+		if err != nil {
+			ReportError(config, decryptor, err)
+		}
+	}
+}
+
+// isLoopback returns true if address only accepts connections from this machine, like "127.0.0.1:8080" or
+// "localhost:8080". An empty host listens on every interface.
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// nextRun returns the next time any schedule runs after now, along with the sources of every schedule that
+// runs at that time. If any of those schedules has no sources, sources is empty, meaning all sources.
+func nextRun(schedules []ScheduleConfig, now time.Time) (time.Time, []string) {
+	var next time.Time
+	var sources []string
+	all := false
+	for _, s := range schedules {
+		t := s.Cron.Next(now)
+		if t.IsZero() {
+			continue
+		}
+		if next.IsZero() || t.Before(next) {
+			next, sources, all = t, nil, false
+		}
+		if t.Equal(next) {
+			all = all || len(s.Sources) == 0
+			sources = append(sources, s.Sources...)
+		}
+	}
+	if all {
+		return next, nil
+	}
+	return next, sources
+}
+
+// lockFile returns the configured lock file, or nw-updater.lock in the user's cache directory, or else the
+// temporary directory.
+func (c Config) lockFile() string {
+	if c.LockFile != "" {
+		return c.LockFile
+	}
+	dir, err := os.UserCacheDir()
+	if err == nil {
+		dir = filepath.Join(dir, "nw-updater")
+		err = os.MkdirAll(dir, 0700)
+	}
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "nw-updater.lock")
+}
+
+// LockedRun calls run while holding the lock file, so that runs from cron and the daemon never overlap.
+// If another run holds the lock, this run is skipped without an error, since it isn't a failure for a cron
+// job to find the previous run still going.
+func LockedRun(lockFile string, run func() error) error {
+	lock, err := Lock(lockFile)
+	if errors.Is(err, ErrLocked) {
+		fmt.Printf("Skipping run, another run holds %s\n", lockFile)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to start run: %w", err)
+	}
+	defer lock.Unlock()
+	return run()
+}
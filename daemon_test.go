@@ -0,0 +1,69 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"nw-updater/schedule"
+)
+
+func TestNextRun(t *testing.T) {
+	cron := func(expr string) schedule.Schedule {
+		s, err := schedule.Parse(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	now := time.Date(2026, 1, 1, 10, 7, 30, 0, time.Local)
+	tests := []struct {
+		name      string
+		schedules []ScheduleConfig
+		next      time.Time
+		sources   []string // nil for all sources
+	}{
+		{name: "earliest schedule", schedules: []ScheduleConfig{
+			{Cron: cron("0 * * * *"), Sources: []string{"fidelity"}},
+			{Cron: cron("30 * * * *"), Sources: []string{"simplefin"}},
+		}, next: time.Date(2026, 1, 1, 10, 30, 0, 0, time.Local), sources: []string{"simplefin"}},
+		{name: "coinciding schedules are merged", schedules: []ScheduleConfig{
+			{Cron: cron("0 * * * *"), Sources: []string{"fidelity"}},
+			{Cron: cron("0 11 * * *"), Sources: []string{"simplefin"}},
+			{Cron: cron("0 12 * * *"), Sources: []string{"igoe"}},
+		}, next: time.Date(2026, 1, 1, 11, 0, 0, 0, time.Local), sources: []string{"fidelity", "simplefin"}},
+		{name: "coinciding schedule with all sources", schedules: []ScheduleConfig{
+			{Cron: cron("0 * * * *"), Sources: []string{"fidelity"}},
+			{Cron: cron("@hourly")},
+		}, next: time.Date(2026, 1, 1, 11, 0, 0, 0, time.Local)},
+		{name: "later schedule with all sources", schedules: []ScheduleConfig{
+			{Cron: cron("30 * * * *"), Sources: []string{"fidelity"}},
+			{Cron: cron("@hourly")},
+		}, next: time.Date(2026, 1, 1, 10, 30, 0, 0, time.Local), sources: []string{"fidelity"}},
+		{name: "no runs", schedules: []ScheduleConfig{{Cron: cron("0 0 30 2 *")}}},
+	}
+	for _, test := range tests {
+		next, sources := nextRun(test.schedules, now)
+		if !next.Equal(test.next) || !slices.Equal(sources, test.sources) || (sources == nil) != (test.sources == nil) {
+			t.Errorf("%s: nextRun = %s %v, expected %s %v", test.name, next, sources, test.next, test.sources)
+		}
+	}
+}
+
+func TestForSources(t *testing.T) {
+	config := Config{
+		SimpleFin:         &SimpleFin{},
+		InstitutionConfig: []InstitutionConfig{{Name: "fidelity"}, {Name: "igoe"}},
+	}
+	// a sync from the trigger or a schedule without sources gets every source
+	if all := config.ForSources(nil); all.SimpleFin == nil || len(all.InstitutionConfig) != 2 {
+		t.Errorf("ForSources(nil) = %+v, expected all sources", all)
+	}
+	some := config.ForSources([]string{"igoe"})
+	if some.SimpleFin != nil || len(some.InstitutionConfig) != 1 || some.InstitutionConfig[0].Name != "igoe" {
+		t.Errorf("ForSources(igoe) = %+v", some)
+	}
+	if config.SimpleFin == nil || len(config.InstitutionConfig) != 2 {
+		t.Errorf("ForSources changed the original config")
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	. "nw-updater/common"
+	"slices"
+	"strings"
+	"time"
+
+	"nw-updater/crypto"
+	"nw-updater/institution"
+)
+
+// DestinationAccount is an account in a Destination that balances can be synced to.
+type DestinationAccount struct {
+	Id       string
+	Name     string
+	Closed   bool
+	OnBudget bool
+}
+
+// ErrAccountNotFound is returned by FindAccount when no account has the id or name.
+var ErrAccountNotFound = errors.New("no account with this id or name")
+
+// FindAccount returns the account whose id is key, or else the only account named key, so that account_mappings
+// can use ids that keep working when an account is renamed, or names for readability.
+func FindAccount(accounts []DestinationAccount, key string) (DestinationAccount, error) {
+	i := slices.IndexFunc(accounts, func(account DestinationAccount) bool {
+		return account.Id == key
+	})
+	if i != -1 {
+		return accounts[i], nil
+	}
+	var found []DestinationAccount
+	for _, account := range accounts {
+		if account.Name == key {
+			found = append(found, account)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return DestinationAccount{}, ErrAccountNotFound
+	case 1:
+		return found[0], nil
+	default:
+		ids := make([]string, len(found))
+		for j, account := range found {
+			ids[j] = account.Id
+		}
+		return DestinationAccount{}, fmt.Errorf("%d accounts are named '%s', map to one of their ids instead: %s",
+			len(found), key, strings.Join(ids, ", "))
+	}
+}
+
+// A Destination is a budgeting app that account balances are synced to by creating adjustment transactions.
+type Destination interface {
+	// Name returns a human-readable name for the destination.
+	Name() string
+	// ListAccounts returns the accounts in the destination.
+	ListAccounts() ([]DestinationAccount, error)
+	// GetBalance returns the balance of an account in cents as of the end of date, leaving out later transactions.
+	GetBalance(account DestinationAccount, date time.Time) (int64, error)
+	// CreateAdjustment creates a transaction for the difference in the adjustment.
+	CreateAdjustment(adjustment Adjustment) error
+}
+
+// A MilliunitDestination stores balances in milliunits, thousandths of a dollar, like YNAB. Adjustments include
+// the part of its balances smaller than a cent, so they match the new balance exactly.
+type MilliunitDestination interface {
+	// GetBalanceMilliunits returns the balance of an account in milliunits as of the end of date.
+	GetBalanceMilliunits(account DestinationAccount, date time.Time) (int64, error)
+}
+
+// getBalance returns the balance of an account in cents, and the rest in milliunits for a MilliunitDestination.
+func getBalance(d Destination, account DestinationAccount, date time.Time) (cents, subCent int64, err error) {
+	if md, ok := d.(MilliunitDestination); ok {
+		milliunits, err := md.GetBalanceMilliunits(account, date)
+		return milliunits / 10, milliunits % 10, err
+	}
+	cents, err = d.GetBalance(account, date)
+	return cents, 0, err
+}
+
+// A DestinationFactory creates a Destination from the config. It returns nil if the destination is not configured.
+type DestinationFactory func(Config, crypto.OpenSslDecryptor) (Destination, error)
+
+var destinations = make(map[string]DestinationFactory)
+
+// Each Destination should register a factory with this function in an init() method so that it is created
+// whenever it is configured.
+func registerDestination(name string, factory DestinationFactory) {
+	destinations[name] = factory
+}
+
+// GetDestinations creates every Destination that is configured, in order of registered name. Destinations that
+// can't be created, like when their API is down, are left out and returned in an [institution.MultiError], so the
+// others can still be updated.
+func GetDestinations(config Config, decryptor crypto.OpenSslDecryptor) ([]Destination, error) {
+	result := make([]Destination, 0, len(destinations))
+	errs := &institution.MultiError{}
+	for _, name := range slices.Sorted(maps.Keys(destinations)) {
+		d, err := destinations[name](config, decryptor)
+		if err != nil {
+			errs.AddError(fmt.Errorf("error creating destination %s: %w", name, err))
+			continue
+		}
+		if d != nil {
+			result = append(result, d)
+		}
+	}
+	if errs.IsEmpty() {
+		return result, nil
+	}
+	return result, errs
+}
+
+// PlanAdjustments gets the balance of each destination account that has a matching balance, as of the date of the
+// matching balance, and returns the adjustments needed to make the account balances match, without creating any
+// transactions.
+// Accounts whose balance can't be retrieved are skipped and returned in an [institution.MultiError].
+func PlanAdjustments(d Destination, balances map[string]AccountBalance) ([]Adjustment, error) {
+	fmt.Printf("Getting accounts from %s...\n", d.Name())
+	accounts, err := d.ListAccounts()
+	if err != nil {
+		return nil, fmt.Errorf("error getting accounts from %s: %w", d.Name(), err)
+	}
+	fmt.Printf("Getting balances in %s...\n", d.Name())
+	adjustments := make([]Adjustment, 0, len(balances))
+	errs := &institution.MultiError{}
+	for _, name := range slices.Sorted(maps.Keys(balances)) {
+		balance := balances[name]
+		account, err := FindAccount(accounts, name)
+		if errors.Is(err, ErrAccountNotFound) {
+			fmt.Printf("No account with id or name '%s' in %s\n", name, d.Name())
+			continue
+		} else if err != nil {
+			errs.AddError(fmt.Errorf("unable to find account for '%s' in %s: %w", name, d.Name(), err))
+			continue
+		}
+		// a balance can't be dated in the future, so this is probably a clock or time zone mistake
+		if balance.BalanceDate.Format(time.DateOnly) > time.Now().Format(time.DateOnly) {
+			errs.AddError(fmt.Errorf("refusing to update '%s' in %s: balance date %s is in the future", name,
+				d.Name(), balance.BalanceDate.Format(time.DateOnly)))
+			continue
+		}
+		current, subCent, err := getBalance(d, account, balance.BalanceDate)
+		if err != nil {
+			errs.AddError(fmt.Errorf("unable to get balance for '%s' in %s: %w", name, d.Name(), err))
+			continue
+		}
+		adjustments = append(adjustments, Adjustment{
+			AccountId:   account.Id,
+			AccountName: account.Name,
+			Mapping:     name,
+			Current:     current,
+			SubCent:     subCent,
+			New:         balance.Balance,
+			Date:        balance.BalanceDate,
+			Source:      balance,
+		})
+	}
+	if errs.IsEmpty() {
+		return adjustments, nil
+	}
+	return adjustments, errs
+}
+
+// UpdateBalances plans the adjustments for a destination and creates a transaction for each one that has changed
+// and passes the guardrails, returning all the planned adjustments and the ones that were created. Errors for individual accounts, including
+// guardrail violations, don't stop the other accounts from being updated. Once ctx is done, the adjustment being
+// created is finished, but no more are started.
+func UpdateBalances(ctx context.Context, d Destination, balances map[string]AccountBalance,
+	guardrails *GuardrailConfig) (planned []Adjustment, applied []Adjustment, err error) {
+
+	adjustments, err := PlanAdjustments(d, balances)
+	errs := &institution.MultiError{}
+	if err != nil {
+		errs.AddError(err)
+	}
+	applied = make([]Adjustment, 0, len(adjustments))
+	for _, adjustment := range adjustments {
+		if ctx.Err() != nil {
+			errs.AddError(fmt.Errorf("stopped updating %s before '%s': %w", d.Name(), adjustment.AccountName,
+				context.Cause(ctx)))
+			break
+		}
+		if adjustment.Unchanged() {
+			fmt.Printf("Account balance has not changed for '%s': (%s)\n", adjustment.AccountName,
+				formatCents(adjustment.New))
+			continue
+		}
+		err = guardrails.Check(adjustment)
+		if err != nil {
+			fmt.Println(err)
+			errs.AddError(fmt.Errorf("%s: %w", d.Name(), err))
+			continue
+		}
+		err = d.CreateAdjustment(adjustment)
+		if err != nil {
+			errs.AddError(fmt.Errorf("unable to update balance for '%s' in %s: %w", adjustment.AccountName,
+				d.Name(), err))
+			continue
+		}
+		applied = append(applied, adjustment)
+	}
+	if errs.IsEmpty() {
+		return adjustments, applied, nil
+	}
+	return adjustments, applied, errs
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"nw-updater/crypto"
+	"nw-updater/institution"
+	"testing"
+)
+
+func TestGetDestinationsKeepsWorkingDestinations(t *testing.T) {
+	registered := destinations
+	t.Cleanup(func() { destinations = registered })
+	working := &fakeDestination{}
+	destinations = map[string]DestinationFactory{
+		"actual": func(Config, crypto.OpenSslDecryptor) (Destination, error) { return working, nil },
+		"ynab": func(Config, crypto.OpenSslDecryptor) (Destination, error) {
+			return nil, errors.New("unable to get budget")
+		},
+		"unconfigured": func(Config, crypto.OpenSslDecryptor) (Destination, error) { return nil, nil },
+	}
+	ds, err := GetDestinations(Config{}, crypto.OpenSslDecryptor{})
+	if len(ds) != 1 || ds[0] != working {
+		t.Errorf("got destinations %v, expected only the working one", ds)
+	}
+	multi, ok := errors.AsType[*institution.MultiError](err)
+	if !ok || len(multi.Errors) != 1 || multi.Error() != "error creating destination ynab: unable to get budget" {
+		t.Errorf("got error %v, expected the ynab error", err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"text/tabwriter"
+
+	"nw-updater/crypto"
+	"nw-updater/institution"
+)
+
+// DiagnoseMain logs in to an institution from the config, printing each browser step and saving the details
+// of any failure, to find which selectors need to be fixed when a site changes.
+func DiagnoseMain(args []string, ctx context.Context, config Config, decryptor crypto.OpenSslDecryptor,
+	newBrowser BrowserFactory) error {
+
+	fs := flag.NewFlagSet("nw-updater diagnose", flag.ExitOnError)
+	instString := fs.String("institution", "", "The institution to diagnose")
+	username := fs.String("username", "", "The username to use in the config file. Optional if there is only one for this institution.")
+	_ = fs.Parse(args)
+	ic, err := config.FindInstitution(*instString, *username)
+	if err != nil {
+		return err
+	}
+	failuresDir := config.FailuresDir
+	if failuresDir == "" {
+		failuresDir = "failures"
+	}
+	balances, err := GetInstitutionBalances(ctx, config, decryptor, newBrowser, ic,
+		func(ctx context.Context) context.Context {
+			ctx = institution.WithVerbose(ctx)
+			return institution.WithFailureDir(ctx, ProfileDir(failuresDir, ic.Name, ic.Auth.Username))
+		})
+	fmt.Printf("\nFound %d matching balances at %s for %s\n", len(balances), ic.Name, ic.Auth.Username)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, account := range slices.Sorted(maps.Keys(balances)) {
+		fmt.Fprintf(tw, "%s\t%s\t\n", account, formatCents(balances[account].Balance))
+	}
+	_ = tw.Flush()
+	for _, e := range UnwrapError(err) {
+		if e == nil {
+			continue
+		}
+		fmt.Printf("\nError: %s\n", e)
+		if ie, ok := errors.AsType[institution.Error](e); ok && ie.Dir != "" {
+			fmt.Printf("Screenshot, page and console errors saved to %s\n", ie.Dir)
+		}
+	}
+	return err
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"html"
 	"io"
 	"strings"
 
@@ -42,9 +43,43 @@ func Email(ec EmailConfig, d crypto.OpenSslDecryptor, e error) error {
 				_, err := w.Write(e.Screenshot)
 				return err
 			}))
+			if e.Url != "" {
+				fmt.Fprintf(body, "<p>URL: %s</p>\n", html.EscapeString(e.Url))
+			}
+			if len(e.ConsoleErrors) > 0 {
+				body.WriteString("<p>Console errors:</p><ul>\n")
+				for _, c := range e.ConsoleErrors {
+					fmt.Fprintf(body, "<li>%s</li>\n", html.EscapeString(c))
+				}
+				body.WriteString("</ul>\n")
+			}
+			if e.Dir != "" {
+				fmt.Fprintf(body, "<p>Details saved to %s</p>\n", html.EscapeString(e.Dir))
+			}
+			if len(e.Html) > 0 {
+				// attached rather than embedded, so the page's markup doesn't affect the email
+				m.Attach(fmt.Sprintf("%d.html", i), gomail.SetCopyFunc(func(w io.Writer) error {
+					_, err := w.Write(e.Html)
+					return err
+				}))
+			}
 		}
 	}
 	m.SetBody("text/html", body.String())
+	return send(ec, d, m)
+}
+
+// EmailMessage sends an email with an HTML body.
+func EmailMessage(ec EmailConfig, d crypto.OpenSslDecryptor, subject, htmlBody string) error {
+	m := gomail.NewMessage()
+	m.SetHeader("From", fmt.Sprintf("nw-updater <%s>", ec.From))
+	m.SetHeader("To", ec.To)
+	m.SetHeader("Subject", subject)
+	m.SetBody("text/html", htmlBody)
+	return send(ec, d, m)
+}
+
+func send(ec EmailConfig, d crypto.OpenSslDecryptor, m *gomail.Message) error {
 	dialer := gomail.NewDialer(ec.Host, ec.Port, ec.From, d.Decrypt(ec.EncryptedPassword))
 	return dialer.DialAndSend(m)
 }
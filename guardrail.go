@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Guardrail contains limits on how much an account balance may change in a single adjustment.
+// Unset fields are inherited from the default guardrail.
+type Guardrail struct {
+	MaxChange        *float64 `yaml:"max_change,omitempty"`         // Maximum absolute change, in dollars
+	MaxPercentChange *float64 `yaml:"max_percent_change,omitempty"` // Maximum change as a percent of the current balance
+	AllowZero        *bool    `yaml:"allow_zero,omitempty"`         // Allow setting a non-zero balance to zero
+}
+
+// GuardrailConfig contains the default Guardrail, and overrides for individual destination accounts.
+type GuardrailConfig struct {
+	Guardrail `yaml:",inline"`
+	Accounts  map[string]Guardrail `yaml:"accounts,omitempty"`
+}
+
+// GuardrailError is returned when an adjustment is refused because of a guardrail.
+type GuardrailError struct {
+	Account string
+	Reason  string
+}
+
+func (e GuardrailError) Error() string {
+	return fmt.Sprintf("refusing to update '%s': %s (use --force to override)", e.Account, e.Reason)
+}
+
+// For returns the guardrail for an account, with any unset fields taken from the default.
+func (g *GuardrailConfig) For(account string) Guardrail {
+	result := g.Guardrail
+	override, ok := g.Accounts[account]
+	if !ok {
+		return result
+	}
+	if override.MaxChange != nil {
+		result.MaxChange = override.MaxChange
+	}
+	if override.MaxPercentChange != nil {
+		result.MaxPercentChange = override.MaxPercentChange
+	}
+	if override.AllowZero != nil {
+		result.AllowZero = override.AllowZero
+	}
+	return result
+}
+
+// Check returns a GuardrailError if the adjustment violates the guardrail for its account.
+// A nil GuardrailConfig allows every adjustment.
+func (g *GuardrailConfig) Check(a Adjustment) error {
+	if g == nil || a.Difference() == 0 {
+		return nil
+	}
+	// overrides can use the mapped id or the account name
+	key := a.AccountName
+	if _, ok := g.Accounts[a.Mapping]; ok {
+		key = a.Mapping
+	}
+	guardrail := g.For(key)
+	if a.New == 0 && a.Current != 0 && (guardrail.AllowZero == nil || !*guardrail.AllowZero) {
+		return GuardrailError{Account: a.AccountName,
+			Reason: fmt.Sprintf("new balance is zero, current balance is %s", formatCents(a.Current))}
+	}
+	change := a.Difference()
+	if change < 0 {
+		change = -change
+	}
+	if guardrail.MaxChange != nil {
+		maxChange := int64(math.Round(*guardrail.MaxChange * 100))
+		if change > maxChange {
+			return GuardrailError{Account: a.AccountName,
+				Reason: fmt.Sprintf("change of %s exceeds maximum of %s", formatCents(a.Difference()), formatCents(maxChange))}
+		}
+	}
+	if guardrail.MaxPercentChange != nil && a.Current != 0 {
+		percent := float64(change) / math.Abs(float64(a.Current)) * 100
+		if percent > *guardrail.MaxPercentChange {
+			return GuardrailError{Account: a.AccountName,
+				Reason: fmt.Sprintf("change of %.1f%% exceeds maximum of %.1f%%", percent, *guardrail.MaxPercentChange)}
+		}
+	}
+	return nil
+}
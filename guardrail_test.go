@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestGuardrailCheck(t *testing.T) {
+	var config GuardrailConfig
+	err := yaml.Unmarshal([]byte(`
+max_change: 1000
+max_percent_change: 10
+accounts:
+  acct-hsa:
+    max_change: 5000
+  Checking:
+    allow_zero: true
+    max_percent_change: 100
+  Volatile:
+    max_percent_change: 50
+`), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		a       Adjustment
+		blocked string // the expected reason, or empty if allowed
+	}{
+		{name: "small change", a: Adjustment{AccountName: "Brokerage", Current: 100000, New: 105000}},
+		{name: "unchanged", a: Adjustment{AccountName: "Brokerage", Current: 100000, New: 100000}},
+		{name: "max change boundary", a: Adjustment{AccountName: "Brokerage", Current: 10000000, New: 10100000}},
+		{name: "max change exceeded", a: Adjustment{AccountName: "Brokerage", Current: 10000000, New: 10100001},
+			blocked: "change of $1000.01 exceeds maximum of $1000.00"},
+		{name: "max change exceeded down", a: Adjustment{AccountName: "Brokerage", Current: 10000000, New: 9800000},
+			blocked: "change of -$2000.00 exceeds maximum of $1000.00"},
+		{name: "max percent boundary", a: Adjustment{AccountName: "Brokerage", Current: 100000, New: 110000}},
+		{name: "max percent exceeded", a: Adjustment{AccountName: "Brokerage", Current: 100000, New: 110001},
+			blocked: "change of 10.0% exceeds maximum of 10.0%"},
+		{name: "max percent of negative balance", a: Adjustment{AccountName: "Brokerage", Current: -100000,
+			New: -80000}, blocked: "change of 20.0% exceeds maximum of 10.0%"},
+		// there's no percent change from zero, so only max_change applies
+		{name: "percent skipped from zero", a: Adjustment{AccountName: "Brokerage", Current: 0, New: 50000}},
+		{name: "max change from zero", a: Adjustment{AccountName: "Brokerage", Current: 0, New: 200000},
+			blocked: "change of $2000.00 exceeds maximum of $1000.00"},
+		{name: "zero blocked", a: Adjustment{AccountName: "Brokerage", Current: 100, New: 0},
+			blocked: "new balance is zero, current balance is $1.00"},
+		{name: "zero to zero", a: Adjustment{AccountName: "Brokerage", Current: 0, New: 0}},
+		{name: "zero allowed by name", a: Adjustment{AccountName: "Checking", Mapping: "acct-chk", Current: 100,
+			New: 0}},
+		{name: "override by mapping", a: Adjustment{AccountName: "HSA", Mapping: "acct-hsa", Current: 10000000,
+			New: 10400000}},
+		{name: "override by mapping keeps default percent", a: Adjustment{AccountName: "HSA", Mapping: "acct-hsa",
+			Current: 1000000, New: 1400000}, blocked: "change of 40.0% exceeds maximum of 10.0%"},
+		{name: "override by name", a: Adjustment{AccountName: "Volatile", Mapping: "acct-vol", Current: 100000,
+			New: 140000}},
+		{name: "override by name keeps default max change", a: Adjustment{AccountName: "Volatile",
+			Mapping: "acct-vol", Current: 1000000, New: 1400000}, blocked: "change of $4000.00 exceeds maximum of $1000.00"},
+		{name: "override by mapping that is a name", a: Adjustment{AccountName: "Brokerage", Mapping: "Checking",
+			Current: 100, New: 0}},
+		{name: "percent still applies with zero allowed", a: Adjustment{AccountName: "Checking", Current: 100,
+			New: 201}, blocked: "change of 101.0% exceeds maximum of 100.0%"},
+	}
+	for _, test := range tests {
+		err := config.Check(test.a)
+		if test.blocked == "" {
+			if err != nil {
+				t.Errorf("%s: blocked with %s", test.name, err)
+			}
+			continue
+		}
+		ge, ok := errors.AsType[GuardrailError](err)
+		if !ok {
+			t.Errorf("%s: returned %v, expected a GuardrailError", test.name, err)
+		} else if ge.Reason != test.blocked || ge.Account != test.a.AccountName {
+			t.Errorf("%s: blocked %s with %q, expected %q", test.name, ge.Account, ge.Reason, test.blocked)
+		}
+	}
+}
+
+func TestGuardrailCheckNil(t *testing.T) {
+	var config *GuardrailConfig
+	if err := config.Check(Adjustment{Current: 100, New: 0}); err != nil {
+		t.Errorf("nil config blocked with %s", err)
+	}
+	// no limits configured still blocks zero balances
+	if err := (&GuardrailConfig{}).Check(Adjustment{Current: 100, New: 0}); err == nil {
+		t.Error("empty config allowed a zero balance")
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	. "nw-updater/common"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// HistoryConfig contains the location of the balance history ledger.
+type HistoryConfig struct {
+	File string `yaml:"file"`
+}
+
+// HistoryRecord is a single balance from a run, along with the adjustments written for it in each destination.
+type HistoryRecord struct {
+	RunId       string    `json:"run_id"`
+	RunTime     time.Time `json:"run_time"`
+	Source      string    `json:"source"`
+	Institution string    `json:"institution,omitempty"`
+	Username    string    `json:"username,omitempty"`
+	Account     string    `json:"account"` // The account_mappings value, a destination account id or name
+	// The id and name of the destination account in each destination it was found in, keyed by destination
+	AccountIds   map[string]string `json:"account_ids,omitempty"`
+	AccountNames map[string]string `json:"account_names,omitempty"`
+	Balance      int64             `json:"balance"`
+	BalanceDate  time.Time         `json:"balance_date"`
+	Adjustments  map[string]int64  `json:"adjustments,omitempty"`
+	// Set when the balance was converted from another currency
+	OriginalBalance  int64   `json:"original_balance,omitempty"`
+	OriginalCurrency string  `json:"original_currency,omitempty"`
+	ExchangeRate     float64 `json:"exchange_rate,omitempty"`
+}
+
+// HistoryStore is an append-only ledger of HistoryRecord, stored as one JSON object per line.
+type HistoryStore struct {
+	Path string
+}
+
+// NewRunId returns an id for a run based on the current time.
+func NewRunId(now time.Time) string {
+	return now.UTC().Format("20060102T150405Z")
+}
+
+// NewHistoryRecords creates a HistoryRecord for each balance, keyed by account_mappings value. planned contains
+// every adjustment planned, to record the destination account each balance was found for, and adjustments
+// contains the adjustments that were written, both keyed by destination name.
+func NewHistoryRecords(runId string, runTime time.Time, balances map[string]AccountBalance,
+	planned, adjustments map[string][]Adjustment) []HistoryRecord {
+
+	records := make([]HistoryRecord, 0, len(balances))
+	for _, account := range slices.Sorted(maps.Keys(balances)) {
+		balance := balances[account]
+		record := HistoryRecord{
+			RunId:       runId,
+			RunTime:     runTime,
+			Source:      balance.Source,
+			Institution: balance.Institution,
+			Username:    balance.Username,
+			Account:     account,
+			Balance:     balance.Balance,
+			BalanceDate: balance.BalanceDate,
+
+			OriginalBalance:  balance.OriginalBalance,
+			OriginalCurrency: balance.OriginalCurrency,
+			ExchangeRate:     balance.ExchangeRate,
+		}
+		for destination, as := range planned {
+			i := slices.IndexFunc(as, func(a Adjustment) bool {
+				return a.Mapping == account
+			})
+			if i != -1 {
+				if record.AccountIds == nil {
+					record.AccountIds = make(map[string]string)
+					record.AccountNames = make(map[string]string)
+				}
+				record.AccountIds[destination] = as[i].AccountId
+				record.AccountNames[destination] = as[i].AccountName
+			}
+		}
+		for destination, as := range adjustments {
+			i := slices.IndexFunc(as, func(a Adjustment) bool {
+				return a.Mapping == account
+			})
+			if i != -1 {
+				if record.Adjustments == nil {
+					record.Adjustments = make(map[string]int64)
+				}
+				record.Adjustments[destination] = as[i].Difference()
+			}
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// Append adds records to the end of the ledger, creating it if it doesn't exist.
+func (h HistoryStore) Append(records []HistoryRecord) error {
+	f, err := os.OpenFile(h.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening history file: %w", err)
+	}
+	defer f.Close()
+	encoder := json.NewEncoder(f)
+	for _, record := range records {
+		err = encoder.Encode(record)
+		if err != nil {
+			return fmt.Errorf("error writing history: %w", err)
+		}
+	}
+	return nil
+}
+
+// Query returns the records for account with a balance date in [from, to). account can be the account_mappings
+// value or a destination account's id or name. An empty account matches all accounts, and a zero from or to
+// is unbounded.
+func (h HistoryStore) Query(account string, from, to time.Time) ([]HistoryRecord, error) {
+	f, err := os.Open(h.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening history file: %w", err)
+	}
+	defer f.Close()
+	records := make([]HistoryRecord, 0)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var record HistoryRecord
+		err = json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			return nil, fmt.Errorf("error reading history line %d: %w", line, err)
+		}
+		if account != "" && !record.HasAccount(account) {
+			continue
+		}
+		if !from.IsZero() && record.BalanceDate.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !record.BalanceDate.Before(to) {
+			continue
+		}
+		records = append(records, record)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading history: %w", err)
+	}
+	return records, nil
+}
+
+// HasAccount returns true if account is the record's account_mappings value, or the id or name of its account in
+// any destination.
+func (r HistoryRecord) HasAccount(account string) bool {
+	return r.Account == account || slices.Contains(slices.Collect(maps.Values(r.AccountIds)), account) ||
+		slices.Contains(slices.Collect(maps.Values(r.AccountNames)), account)
+}
+
+// DisplayName returns the names of the record's account in its destinations, or its account_mappings value if
+// it wasn't found in any, since the value is usually an id.
+func (r HistoryRecord) DisplayName() string {
+	names := slices.Compact(slices.Sorted(maps.Values(r.AccountNames)))
+	if len(names) == 0 {
+		return r.Account
+	}
+	return strings.Join(names, ", ")
+}
+
+// WriteHistoryCsv writes records as CSV with an adjustment column for each destination.
+// Amounts are written in dollars.
+func WriteHistoryCsv(w io.Writer, records []HistoryRecord) error {
+	destinationSet := make(map[string]bool)
+	for _, record := range records {
+		for destination := range record.Adjustments {
+			destinationSet[destination] = true
+		}
+	}
+	destinationNames := slices.Sorted(maps.Keys(destinationSet))
+	cw := csv.NewWriter(w)
+	header := []string{"run_id", "run_time", "source", "institution", "username", "account", "account_name", "balance",
+		"balance_date"}
+	for _, destination := range destinationNames {
+		header = append(header, destination+" adjustment")
+	}
+	_ = cw.Write(header)
+	for _, r := range records {
+		row := []string{r.RunId, r.RunTime.Format(time.RFC3339), r.Source, r.Institution, r.Username, r.Account,
+			r.DisplayName(), centsToDecimal(r.Balance), r.BalanceDate.Format(time.DateOnly)}
+		for _, destination := range destinationNames {
+			adjustment, ok := r.Adjustments[destination]
+			if ok {
+				row = append(row, centsToDecimal(adjustment))
+			} else {
+				row = append(row, "")
+			}
+		}
+		_ = cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// centsToDecimal formats an amount in cents as a plain decimal number, like -1234.56.
+func centsToDecimal(cents int64) string {
+	return strconv.FormatFloat(float64(cents)/100.0, 'f', 2, 64)
+}
+
+// HistoryMain queries the balance history ledger, printing a table or writing CSV.
+func HistoryMain(args []string, config *HistoryConfig) error {
+	fs := flag.NewFlagSet("nw-updater history", flag.ExitOnError)
+	account := fs.String("account", "", "Only show history for this destination account name or id")
+	fromFlag := fs.String("from", "", "Only show balances on or after this date (YYYY-MM-DD)")
+	toFlag := fs.String("to", "", "Only show balances on or before this date (YYYY-MM-DD)")
+	csvFile := fs.String("csv", "", "Write CSV to this file instead of printing a table, - for stdout")
+	_ = fs.Parse(args)
+	if config == nil {
+		return errors.New("history is not configured")
+	}
+	var from, to time.Time
+	var err error
+	if *fromFlag != "" {
+		from, err = time.ParseInLocation(time.DateOnly, *fromFlag, time.Local)
+		if err != nil {
+			return fmt.Errorf("invalid --from date: %w", err)
+		}
+	}
+	if *toFlag != "" {
+		to, err = time.ParseInLocation(time.DateOnly, *toFlag, time.Local)
+		if err != nil {
+			return fmt.Errorf("invalid --to date: %w", err)
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	records, err := HistoryStore{Path: config.File}.Query(*account, from, to)
+	if err != nil {
+		return err
+	}
+	switch *csvFile {
+	case "":
+		printHistory(os.Stdout, records)
+		return nil
+	case "-":
+		return WriteHistoryCsv(os.Stdout, records)
+	default:
+		f, err := os.OpenFile(*csvFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("error opening csv file: %w", err)
+		}
+		defer f.Close()
+		return WriteHistoryCsv(f, records)
+	}
+}
+
+// printHistory writes a table of history records.
+func printHistory(w io.Writer, records []HistoryRecord) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Run\tAccount\tSource\tBalance\tDate\tAdjustments\t")
+	for _, r := range records {
+		adjustments := make([]string, 0, len(r.Adjustments))
+		for _, destination := range slices.Sorted(maps.Keys(r.Adjustments)) {
+			adjustments = append(adjustments, fmt.Sprintf("%s: %s", destination, formatCents(r.Adjustments[destination])))
+		}
+		source := r.Source
+		if r.Institution != "" {
+			source = fmt.Sprintf("%s (%s)", r.Institution, r.Username)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t\n", r.RunId, r.DisplayName(), source, formatCents(r.Balance),
+			r.BalanceDate.Format(time.DateOnly), strings.Join(adjustments, ", "))
+	}
+	_ = tw.Flush()
+}
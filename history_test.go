@@ -0,0 +1,121 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestHistory writes a ledger with a balance for a brokerage and a checking account on each of three days.
+func writeTestHistory(t *testing.T) HistoryStore {
+	h := HistoryStore{Path: filepath.Join(t.TempDir(), "history.jsonl")}
+	runTime := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	var records []HistoryRecord
+	for day := 1; day <= 3; day++ {
+		date := time.Date(2026, 3, day, 0, 0, 0, 0, time.Local)
+		records = append(records,
+			HistoryRecord{RunId: NewRunId(runTime), RunTime: runTime, Source: "fidelity", Institution: "fidelity",
+				Username: "user", Account: "a1", AccountIds: map[string]string{"YNAB": "a1", "Actual": "b1"},
+				AccountNames: map[string]string{"YNAB": "Brokerage", "Actual": "Investments"},
+				Balance:      int64(day) * 100050, BalanceDate: date, Adjustments: map[string]int64{"YNAB": -1250}},
+			HistoryRecord{RunId: NewRunId(runTime), RunTime: runTime, Source: "manual", Account: "Checking",
+				Balance: -500, BalanceDate: date})
+	}
+	if err := h.Append(records); err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestHistoryQuery(t *testing.T) {
+	h := writeTestHistory(t)
+	march := func(day int) time.Time {
+		return time.Date(2026, 3, day, 0, 0, 0, 0, time.Local)
+	}
+	tests := []struct {
+		account  string
+		from, to time.Time
+		expected []string // the account and day of each record
+	}{
+		{expected: []string{"a1 1", "Checking 1", "a1 2", "Checking 2", "a1 3", "Checking 3"}},
+		{account: "a1", expected: []string{"a1 1", "a1 2", "a1 3"}},
+		{account: "b1", expected: []string{"a1 1", "a1 2", "a1 3"}},
+		{account: "Brokerage", expected: []string{"a1 1", "a1 2", "a1 3"}},
+		{account: "Investments", expected: []string{"a1 1", "a1 2", "a1 3"}},
+		{account: "Checking", expected: []string{"Checking 1", "Checking 2", "Checking 3"}},
+		{account: "Savings"},
+		{account: "a1", from: march(2), expected: []string{"a1 2", "a1 3"}},
+		{account: "a1", to: march(3), expected: []string{"a1 1", "a1 2"}},
+		{account: "a1", from: march(2), to: march(3), expected: []string{"a1 2"}},
+	}
+	for _, test := range tests {
+		records, err := h.Query(test.account, test.from, test.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, 0, len(records))
+		for _, r := range records {
+			got = append(got, r.Account+" "+r.BalanceDate.Format("2"))
+		}
+		if !slices.Equal(got, test.expected) {
+			t.Errorf("Query(%q, %s, %s) = %v, expected %v", test.account, test.from.Format(time.DateOnly),
+				test.to.Format(time.DateOnly), got, test.expected)
+		}
+	}
+}
+
+func TestHistoryQueryMissingFile(t *testing.T) {
+	records, err := HistoryStore{Path: filepath.Join(t.TempDir(), "missing.jsonl")}.Query("", time.Time{}, time.Time{})
+	if err != nil || len(records) != 0 {
+		t.Errorf("Query of a missing ledger = %v, %v, expected no records", records, err)
+	}
+}
+
+func TestHistoryMainCsv(t *testing.T) {
+	h := writeTestHistory(t)
+	out := filepath.Join(t.TempDir(), "history.csv")
+	// --to includes balances on that date
+	err := HistoryMain([]string{"--account", "Brokerage", "--from", "2026-03-02", "--to", "2026-03-03", "--csv", out},
+		&HistoryConfig{File: h.Path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		"run_id,run_time,source,institution,username,account,account_name,balance,balance_date,YNAB adjustment",
+		"20260301T120000Z,2026-03-01T12:00:00Z,fidelity,fidelity,user,a1,\"Brokerage, Investments\",2001.00,2026-03-02,-12.50",
+		"20260301T120000Z,2026-03-01T12:00:00Z,fidelity,fidelity,user,a1,\"Brokerage, Investments\",3001.50,2026-03-03,-12.50",
+	}, "\n") + "\n"
+	if string(b) != expected {
+		t.Errorf("csv is:\n%s\nexpected:\n%s", b, expected)
+	}
+}
+
+func TestWriteHistoryCsv(t *testing.T) {
+	records := []HistoryRecord{
+		{RunId: "r1", RunTime: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC), Source: "manual", Account: "Checking",
+			Balance: -500, BalanceDate: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+			Adjustments: map[string]int64{"YNAB": 5}},
+		{RunId: "r1", RunTime: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC), Source: "manual", Account: "a2",
+			AccountNames: map[string]string{"Actual": "Savings"}, Balance: 100,
+			BalanceDate: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), Adjustments: map[string]int64{"Actual": -100}},
+	}
+	b := &strings.Builder{}
+	if err := WriteHistoryCsv(b, records); err != nil {
+		t.Fatal(err)
+	}
+	// a column for each destination, empty for records without an adjustment in it
+	expected := "run_id,run_time,source,institution,username,account,account_name,balance,balance_date," +
+		"Actual adjustment,YNAB adjustment\n" +
+		"r1,2026-03-01T12:00:00Z,manual,,,Checking,Checking,-5.00,2026-03-01,,0.05\n" +
+		"r1,2026-03-01T12:00:00Z,manual,,,a2,Savings,1.00,2026-03-01,-1.00,\n"
+	if b.String() != expected {
+		t.Errorf("csv is:\n%s\nexpected:\n%s", b, expected)
+	}
+}
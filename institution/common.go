@@ -5,12 +5,11 @@ package institution
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	. "nw-updater/common"
-	"regexp"
 	"runtime/debug"
 	"slices"
-	"strconv"
 	"strings"
 	"time"
 
@@ -19,6 +18,8 @@ import (
 	"github.com/chromedp/chromedp"
 
 	"nw-updater/crypto"
+	"nw-updater/money"
+	"nw-updater/totp"
 )
 
 // Auth contains authentication information for an institution.
@@ -26,6 +27,8 @@ type Auth struct {
 	Username          string
 	EncryptedPassword string `yaml:"encrypted_password"`
 	Questions         map[string]string
+	// Base32 secret or otpauth:// URI for generating security codes, so MFA can be completed unattended.
+	EncryptedTotpSecret string `yaml:"encrypted_totp_secret,omitempty"`
 }
 
 type LoginResult uint8
@@ -47,6 +50,39 @@ type SecurityCode interface {
 	Institution
 }
 
+// ErrCodeRequired is returned by GetBalances when a SecurityCode institution needs a security code to log in.
+var ErrCodeRequired = errors.New("code required")
+
+// A CodeProvider gets a security code from the user after an institution has sent it.
+type CodeProvider interface {
+	GetCode(ctx context.Context, institution, username string) (string, error)
+}
+
+// TerminalCodeProvider prompts for security codes on the terminal.
+type TerminalCodeProvider struct {
+}
+
+func (t TerminalCodeProvider) GetCode(_ context.Context, _, _ string) (string, error) {
+	return UserInput("Enter code: "), nil
+}
+
+// CompleteMfa logs in to a SecurityCode institution so that it sends a security code, gets the code from
+// provider, and enters it.
+func CompleteMfa(ctx context.Context, sc SecurityCode, name string, auth Auth, d crypto.OpenSslDecryptor,
+	provider CodeProvider) error {
+
+	ctx, cancel, err := sc.RequestCode(ctx, auth, d)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	code, err := provider.GetCode(ctx, name, auth.Username)
+	if err != nil {
+		return fmt.Errorf("failed to get security code for %s: %w", name, err)
+	}
+	return sc.EnterCode(ctx, code)
+}
+
 type MultiError struct {
 	Errors []error
 }
@@ -63,10 +99,15 @@ func (m *MultiError) IsEmpty() bool {
 	return len(m.Errors) == 0
 }
 
+// Error is an error from an institution page, with details of the page to help fix selectors when the site changes.
 type Error struct {
-	Wrapped    error
-	Screenshot []byte
-	Stacktrace []byte
+	Wrapped       error
+	Screenshot    []byte
+	Stacktrace    []byte
+	Html          []byte   // The page's HTML, without scripts or input values
+	Url           string   // The page's URL
+	ConsoleErrors []string // Recent errors from the page's console
+	Dir           string   // Where the details were saved, if a failure directory was set with WithFailureDir
 }
 
 func (e Error) Error() string {
@@ -93,32 +134,56 @@ func MustGet(name string) Institution {
 	return institution
 }
 
-// newContext creates a new chromedp context for each institution, using an existing tab if the urlPrefix matches
-// an open one, which is only currently used when debugging with an existing chrome instance with a websocket.
-func newContext(ctx context.Context, urlPrefix string) (context.Context, context.CancelFunc) {
+// defaultTimeout is the timeout for an institution tab when the parent context has no deadline.
+const defaultTimeout = 5 * time.Minute
+
+// newContext creates a new chromedp context for each institution, using an existing tab if any of urlPrefixes
+// matches an open one, which is only currently used when debugging with an existing chrome instance with
+// a websocket.
+// The returned cancel function closes the tab and waits for it to be closed, so that tabs can be opened and
+// closed concurrently by different institutions.
+func newContext(ctx context.Context, urlPrefixes ...string) (context.Context, context.CancelFunc, error) {
+	for i, urlPrefix := range urlPrefixes {
+		urlPrefixes[i] = siteUrl(ctx, urlPrefix)
+	}
 	// get the list of the targets
 	infos, err := chromedp.Targets(ctx)
 	if err != nil {
-		panic(err)
+		return nil, nil, fmt.Errorf("failed to list browser tabs: %w", err)
 	}
 	i := slices.IndexFunc(infos, func(info *target.Info) bool {
-		return strings.HasPrefix(info.URL, urlPrefix)
+		return slices.ContainsFunc(urlPrefixes, func(urlPrefix string) bool {
+			return strings.HasPrefix(info.URL, urlPrefix)
+		})
 	})
+	var tabCtx context.Context
 	var cancel1 context.CancelFunc
 	if i != -1 {
-		ctx, cancel1 = chromedp.NewContext(ctx, chromedp.WithTargetID(infos[i].TargetID))
+		tabCtx, cancel1 = chromedp.NewContext(ctx, chromedp.WithTargetID(infos[i].TargetID))
 	} else {
-		ctx, cancel1 = chromedp.NewContext(ctx)
+		tabCtx, cancel1 = chromedp.NewContext(ctx)
+	}
+	tabCtx = listenConsole(tabCtx)
+	cancel := func() {
+		// chromedp.Cancel waits for the tab to close, unlike cancel1
+		_ = chromedp.Cancel(tabCtx)
+		cancel1()
+	}
+	ctx = tabCtx
+	cancel2 := func() {}
+	if _, ok := ctx.Deadline(); !ok {
+		ctx, cancel2 = context.WithTimeout(ctx, defaultTimeout)
 	}
-	ctx, cancel2 := context.WithTimeout(ctx, 5*time.Minute)
 	// create tab so we can take a screenshot later on this context.
 	if err = chromedp.Run(ctx); err != nil {
-		panic(err)
+		cancel2()
+		cancel()
+		return nil, nil, fmt.Errorf("failed to open browser tab: %w", err)
 	}
 	return ctx, func() {
 		cancel2()
-		cancel1()
-	}
+		cancel()
+	}, nil
 }
 
 func screenshotError(ctx context.Context, err error) error {
@@ -133,18 +198,36 @@ func screenshotError(ctx context.Context, err error) error {
 	if err2 != nil {
 		fmt.Printf("Failed to take screenshot of error: %s\n", err2)
 	}
-	return Error{
-		Wrapped:    err,
-		Screenshot: buf,
-		Stacktrace: stack,
+	var page struct {
+		Url  string `json:"url"`
+		Html string `json:"html"`
+	}
+	err2 = chromedp.Run(ctx, chromedp.Evaluate(sanitizeScript, &page))
+	if err2 != nil {
+		fmt.Printf("Failed to get page of error: %s\n", err2)
+	}
+	e := Error{
+		Wrapped:       err,
+		Screenshot:    buf,
+		Stacktrace:    stack,
+		Html:          []byte(page.Html),
+		Url:           page.Url,
+		ConsoleErrors: tabConsoleErrors(ctx),
+	}
+	e.Dir, err2 = e.save(ctx)
+	if err2 != nil {
+		fmt.Printf("Failed to save details of error: %s\n", err2)
+	} else if e.Dir != "" {
+		fmt.Printf("Saved details of error to %s\n", e.Dir)
 	}
+	return e
 }
 
 // getMultipleBalances is a utility function used by an Institution to retrieve multiple balances from
 // a single page. The Institution provides the nodes containing the account name and balance,
-// and selectors for the name and balance inside each node.
+// selectors for the name and balance inside each node, and the locale the balances are formatted in.
 func getMultipleBalances(nodes []*cdp.Node, parentCtx context.Context, mappings map[string]string, nameSelector,
-	balSelector string) (map[string]AccountBalance, error) {
+	balSelector string, locale money.Locale) (map[string]AccountBalance, error) {
 
 	ctx, cancel := context.WithTimeout(parentCtx, 1*time.Minute)
 	defer cancel()
@@ -153,7 +236,7 @@ func getMultipleBalances(nodes []*cdp.Node, parentCtx context.Context, mappings
 	errs := &MultiError{}
 	for _, node := range nodes {
 		var name, balance string
-		err := chromedp.Run(ctx,
+		err := run(ctx,
 			chromedp.TextContent(nameSelector, &name, chromedp.ByQuery, chromedp.FromNode(node)),
 			chromedp.TextContent(balSelector, &balance, chromedp.ByQuery, chromedp.FromNode(node)))
 		if err != nil {
@@ -164,7 +247,7 @@ func getMultipleBalances(nodes []*cdp.Node, parentCtx context.Context, mappings
 		trimmedName := strings.TrimSpace(name)
 		mapping, ok := mappings[trimmedName]
 		if ok {
-			balanceNum, err := parseCents(balance)
+			balanceNum, err := money.ParseCentsLocale(balance, locale)
 			if err != nil {
 				err = fmt.Errorf("failed to parse balance '%s': %w", balance, err)
 				errs.AddError(screenshotError(parentCtx, err))
@@ -175,6 +258,7 @@ func getMultipleBalances(nodes []*cdp.Node, parentCtx context.Context, mappings
 				BalanceDate: time.Now(),
 				Id:          mapping,
 				Name:        mapping,
+				Currency:    money.DetectCurrency(balance),
 			}
 		}
 	}
@@ -184,12 +268,67 @@ func getMultipleBalances(nodes []*cdp.Node, parentCtx context.Context, mappings
 	return balances, errs
 }
 
-var centsPattern = regexp.MustCompile(`\D`)
+// getSelectorBalances is a utility function used by an Institution to retrieve balances that are each in their own
+// element on a page, rather than in a list. selectors maps account names to the selector of each balance, and the
+// balances are formatted in locale.
+func getSelectorBalances(parentCtx context.Context, mappings map[string]string,
+	selectors map[string]string, locale money.Locale) (map[string]AccountBalance, error) {
+
+	ctx, cancel := context.WithTimeout(parentCtx, 1*time.Minute)
+	defer cancel()
 
-// parseCents parses a string for a balance, removing all non-numeric characters and parsing to int64.
-func parseCents(str string) (int64, error) {
-	numsOnly := centsPattern.ReplaceAllString(str, "")
-	return strconv.ParseInt(numsOnly, 10, 64)
+	balances := make(map[string]AccountBalance)
+	errs := &MultiError{}
+	for name, selector := range selectors {
+		mapping, ok := mappings[name]
+		if !ok {
+			continue
+		}
+		var balance string
+		err := run(ctx, chromedp.TextContent(selector, &balance, chromedp.ByQuery))
+		if err != nil {
+			err = fmt.Errorf("failed to find balance for '%s': %w", name, err)
+			errs.AddError(screenshotError(parentCtx, err))
+			continue
+		}
+		balanceNum, err := money.ParseCentsLocale(balance, locale)
+		if err != nil {
+			err = fmt.Errorf("failed to parse balance '%s': %w", balance, err)
+			errs.AddError(screenshotError(parentCtx, err))
+			continue
+		}
+		balances[mapping] = AccountBalance{
+			Balance:     balanceNum,
+			BalanceDate: time.Now(),
+			Id:          mapping,
+			Name:        mapping,
+			Currency:    money.DetectCurrency(balance),
+		}
+	}
+	if errs.IsEmpty() {
+		return balances, nil
+	}
+	return balances, errs
+}
+
+// totpCode generates a security code from the TOTP secret in auth, returning false if there is no secret.
+// If the current code is about to expire, it waits for the next one so there is time to submit it.
+func totpCode(ctx context.Context, auth Auth, d crypto.OpenSslDecryptor) (string, bool, error) {
+	if auth.EncryptedTotpSecret == "" {
+		return "", false, nil
+	}
+	key, err := totp.ParseKey(d.Decrypt(auth.EncryptedTotpSecret))
+	if err != nil {
+		return "", false, err
+	}
+	if remaining := key.Remaining(time.Now()); remaining < 5*time.Second {
+		select {
+		case <-time.After(remaining):
+		case <-ctx.Done():
+			return "", false, ctx.Err()
+		}
+	}
+	return key.Code(time.Now()), true, nil
 }
 
 func UserInput(prompt string) string {
@@ -0,0 +1,208 @@
+package institution
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	goruntime "runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/log"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+type verboseKey struct{}
+
+// WithVerbose returns a context that makes institutions print each chromedp action they run, with its selector,
+// how long it took and whether it failed, to find which step broke when a site changes.
+func WithVerbose(ctx context.Context) context.Context {
+	return context.WithValue(ctx, verboseKey{}, true)
+}
+
+// run runs actions like chromedp.Run, but one at a time with logging if WithVerbose was used.
+func run(ctx context.Context, actions ...chromedp.Action) error {
+	if verbose, _ := ctx.Value(verboseKey{}).(bool); !verbose || len(actions) == 0 {
+		return chromedp.Run(ctx, actions...)
+	}
+	caller := "unknown"
+	if _, file, line, ok := goruntime.Caller(1); ok {
+		caller = fmt.Sprintf("%s:%d", filepath.Base(file), line)
+	}
+	for i, action := range actions {
+		start := time.Now()
+		err := chromedp.Run(ctx, action)
+		result := "ok"
+		if err != nil {
+			result = "failed: " + err.Error()
+		}
+		fmt.Printf("[%s step %d] %s (%s) %s\n", caller, i+1, describeAction(action),
+			time.Since(start).Round(time.Millisecond), result)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// describeAction returns a readable description of a chromedp action. chromedp doesn't export the details of
+// its query actions, so the selector and the functions that wait for and act on the nodes are found by reflection.
+// Every field is checked before use, so if chromedp changes them the description falls back to the type.
+func describeAction(action chromedp.Action) string {
+	typeName := strings.TrimPrefix(fmt.Sprintf("%T", action), "chromedp.")
+	v := reflect.Indirect(reflect.ValueOf(action))
+	if v.Kind() != reflect.Struct || v.Type() != reflect.TypeFor[chromedp.Selector]() {
+		return typeName
+	}
+	sel := v.FieldByName("sel")
+	if !sel.IsValid() {
+		return typeName
+	}
+	parts := []string{fmt.Sprintf("query %v", sel)}
+	if wait := v.FieldByName("wait"); wait.IsValid() && wait.Kind() == reflect.Func && !wait.IsNil() {
+		parts = append(parts, "wait "+funcName(wait))
+	}
+	if after := v.FieldByName("after"); after.IsValid() && after.Kind() == reflect.Slice {
+		for i := range after.Len() {
+			if f := after.Index(i); f.Kind() == reflect.Func && !f.IsNil() {
+				parts = append(parts, "then "+funcName(f))
+			}
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// funcName returns the name of the chromedp function that created the closure f, like "SetJavascriptAttribute".
+func funcName(f reflect.Value) string {
+	fn := goruntime.FuncForPC(f.Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+	name := fn.Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	name = strings.TrimPrefix(name, "chromedp.")
+	name = strings.TrimPrefix(name, "(*Selector).")
+	name, _, _ = strings.Cut(name, ".func")
+	return name
+}
+
+// maxConsoleErrors is the number of recent console errors kept for each tab.
+const maxConsoleErrors = 50
+
+type consoleKey struct{}
+
+// consoleErrors collects the errors logged to the console of a tab, to include in an Error.
+type consoleErrors struct {
+	mu     sync.Mutex
+	errors []string
+}
+
+// listenConsole returns a context that collects the console errors of the tab in ctx.
+func listenConsole(ctx context.Context) context.Context {
+	c := &consoleErrors{}
+	chromedp.ListenTarget(ctx, func(ev any) {
+		switch ev := ev.(type) {
+		case *runtime.EventConsoleAPICalled:
+			if ev.Type == runtime.APITypeError {
+				args := make([]string, 0, len(ev.Args))
+				for _, arg := range ev.Args {
+					if len(arg.Value) > 0 {
+						args = append(args, string(arg.Value))
+					} else {
+						args = append(args, arg.Description)
+					}
+				}
+				c.add("console.error: " + strings.Join(args, " "))
+			}
+		case *runtime.EventExceptionThrown:
+			details := ev.ExceptionDetails
+			text := details.Text
+			if details.Exception != nil && details.Exception.Description != "" {
+				text += " " + details.Exception.Description
+			}
+			c.add(fmt.Sprintf("exception at %s:%d: %s", details.URL, details.LineNumber+1, text))
+		case *log.EventEntryAdded:
+			if ev.Entry.Level == log.LevelError {
+				c.add(fmt.Sprintf("%s error: %s %s", ev.Entry.Source, ev.Entry.Text, ev.Entry.URL))
+			}
+		}
+	})
+	return context.WithValue(ctx, consoleKey{}, c)
+}
+
+func (c *consoleErrors) add(s string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errors = append(c.errors, s)
+	if len(c.errors) > maxConsoleErrors {
+		c.errors = c.errors[len(c.errors)-maxConsoleErrors:]
+	}
+}
+
+// tabConsoleErrors returns the console errors collected for the tab in ctx so far.
+func tabConsoleErrors(ctx context.Context) []string {
+	c, ok := ctx.Value(consoleKey{}).(*consoleErrors)
+	if !ok {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.errors...)
+}
+
+type failureDirKey struct{}
+
+// WithFailureDir returns a context that makes institutions save the details of each Error to a new directory
+// in dir named by the time of the failure, so they are still available after the email is gone.
+func WithFailureDir(ctx context.Context, dir string) context.Context {
+	return context.WithValue(ctx, failureDirKey{}, dir)
+}
+
+// save writes the screenshot, page and details of e to a new timestamped directory in the failure directory
+// from ctx, returning the directory, or "" if there is no failure directory.
+func (e Error) save(ctx context.Context) (string, error) {
+	base, ok := ctx.Value(failureDirKey{}).(string)
+	if !ok {
+		return "", nil
+	}
+	name := time.Now().Format("20060102-150405.000")
+	dir := filepath.Join(base, name)
+	for i := 2; ; i++ {
+		_, err := os.Stat(dir)
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		dir = filepath.Join(base, fmt.Sprintf("%s-%d", name, i))
+	}
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+	details := &strings.Builder{}
+	fmt.Fprintf(details, "Error: %s\nURL: %s\n\nConsole errors:\n", e.Wrapped, e.Url)
+	for _, c := range e.ConsoleErrors {
+		fmt.Fprintf(details, "%s\n", c)
+	}
+	fmt.Fprintf(details, "\nStack trace:\n%s", e.Stacktrace)
+	files := map[string][]byte{
+		"error.txt":      []byte(details.String()),
+		"page.html":      e.Html,
+		"screenshot.png": e.Screenshot,
+	}
+	for file, contents := range files {
+		if len(contents) == 0 {
+			continue
+		}
+		err = os.WriteFile(filepath.Join(dir, file), contents, 0600)
+		if err != nil {
+			return "", err
+		}
+	}
+	return dir, nil
+}
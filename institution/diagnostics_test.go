@@ -0,0 +1,25 @@
+package institution
+
+import (
+	"testing"
+
+	"github.com/chromedp/chromedp"
+)
+
+func TestDescribeAction(t *testing.T) {
+	tests := []struct {
+		action   chromedp.Action
+		expected string
+	}{
+		{action: chromedp.Click("#login", chromedp.ByQuery),
+			expected: "query #login, wait waitReady, then Click"},
+		{action: chromedp.WaitVisible(".balance", chromedp.ByQuery), expected: "query .balance, wait waitReady"},
+		{action: chromedp.Navigate("https://example.com"), expected: "ActionFunc"},
+		{action: &chromedp.Selector{}, expected: "query <nil>"},
+	}
+	for _, test := range tests {
+		if got := describeAction(test.action); got != test.expected {
+			t.Errorf("describeAction(%T) = %q, expected %q", test.action, got, test.expected)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package institution
 import (
 	"context"
 	"errors"
+	"fmt"
 	. "nw-updater/common"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 	"github.com/chromedp/chromedp"
 
 	"nw-updater/crypto"
+	"nw-updater/money"
 )
 
 const (
@@ -17,13 +19,22 @@ const (
 	fidelityUrlPrefix = "https://digital.fidelity.com"
 )
 
+var fidelitySession = sessionSite{
+	Urls:             []string{fidelityLoginUrl, fidelityUrlPrefix + "/ftgw/digital/portfolio/summary"},
+	AccountsUrl:      fidelityUrlPrefix + "/ftgw/digital/portfolio/summary",
+	AccountsSelector: ".acct-selector__acct-list",
+}
+
 type fidelity struct {
 }
 
 func (f fidelity) RequestCode(ctx context.Context, auth Auth, d crypto.OpenSslDecryptor) (context.Context, context.CancelFunc, error) {
 	// begin login process
 	doCancel := true
-	ctx, cancel := newContext(ctx, fidelityUrlPrefix)
+	ctx, cancel, err := newContext(ctx, fidelityUrlPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
 	defer func() {
 		if doCancel {
 			cancel()
@@ -49,11 +60,12 @@ func (f fidelity) EnterCode(parentCtx context.Context, code string) error {
 	ctx, cancel := context.WithTimeout(parentCtx, 1*time.Minute)
 	defer cancel()
 	// enter code into existing browser
-	err := chromedp.Run(ctx,
+	err := run(ctx,
 		chromedp.SetValue("#dom-totp-security-code-input", code),
 		chromedp.Click("#dom-trust-device-checkbox"),
 		chromedp.Click("#dom-totp-code-continue-button"),
-		chromedp.WaitVisible(".acct-selector__acct-list"))
+		chromedp.WaitVisible(".acct-selector__acct-list"),
+		capturePage())
 	return screenshotError(parentCtx, err)
 }
 
@@ -64,39 +76,64 @@ func init() {
 func (f fidelity) GetBalances(parentCtx context.Context, auth Auth, d crypto.OpenSslDecryptor,
 	mappings map[string]string) (map[string]AccountBalance, error) {
 
-	browserCtx, cancel := newContext(parentCtx, fidelityUrlPrefix)
+	browserCtx, cancel, err := newContext(parentCtx, fidelityUrlPrefix)
+	if err != nil {
+		return nil, err
+	}
 	defer cancel()
-	result, screenshot := f.startAuth(browserCtx, auth.Username, d.Decrypt(auth.EncryptedPassword))
+	result, screenshot := LoginOk, error(nil)
+	if !restoreSession(browserCtx, d, fidelitySession) {
+		result, screenshot = f.startAuth(browserCtx, auth.Username, d.Decrypt(auth.EncryptedPassword))
+	}
+	if result == CodeRequired {
+		// complete MFA unattended if there is a TOTP secret
+		code, ok, err := totpCode(browserCtx, auth, d)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate security code: %w", err)
+		}
+		if ok {
+			err = f.EnterCode(browserCtx, code)
+			if err != nil {
+				return nil, err
+			}
+			result = LoginOk
+		}
+	}
 	if result != LoginOk {
 		return nil, screenshot
 	}
 	ctx, cancel := context.WithTimeout(browserCtx, 1*time.Minute)
 	defer cancel()
 	var nodes []*cdp.Node
-	err := chromedp.Run(ctx, chromedp.Nodes(".acct-selector__acct-content", &nodes, chromedp.ByQueryAll))
+	err = run(ctx, chromedp.Nodes(".acct-selector__acct-content", &nodes, chromedp.ByQueryAll))
 	if err != nil {
 		return nil, screenshotError(browserCtx, err)
 	}
+	saveSession(browserCtx, d, fidelitySession)
 	return getMultipleBalances(nodes, browserCtx, mappings,
-		".acct-selector__acct-name span:not(.sr-only)", ".acct-selector__acct-balance span:not(.sr-only)")
+		".acct-selector__acct-name span:not(.sr-only)", ".acct-selector__acct-balance span:not(.sr-only)",
+		money.US)
 }
 
 func (f fidelity) startAuth(parentCtx context.Context, username, password string) (LoginResult, error) {
 	ctx, cancel := context.WithTimeout(parentCtx, 1*time.Minute)
 	defer cancel()
 	var accountNodes []*cdp.Node
-	err := chromedp.Run(ctx,
-		chromedp.Navigate(fidelityLoginUrl),
+	err := run(ctx,
+		chromedp.Navigate(siteUrl(ctx, fidelityLoginUrl)),
+		chromedp.WaitReady("#dom-username-input"),
+		capturePage(),
 		chromedp.SetValue("#dom-username-input", username),
 		chromedp.SetValue("#dom-pswd-input", password),
 		chromedp.Click("#dom-login-button"),
 		chromedp.WaitVisible("//*[contains(@class,\"acct-selector__acct-list\")] | //h1[contains(.,\"Enter the code from your authenticator app\")]"),
+		capturePage(),
 		chromedp.Nodes(".acct-selector__acct-list", &accountNodes, chromedp.AtLeast(0)))
 	if err != nil {
 		return LoginError, screenshotError(parentCtx, err)
 	}
 	if len(accountNodes) == 0 {
-		return CodeRequired, screenshotError(parentCtx, errors.New("code required"))
+		return CodeRequired, screenshotError(parentCtx, ErrCodeRequired)
 	}
 	return LoginOk, errors.New("login ok")
 }
@@ -0,0 +1,36 @@
+package institution
+
+import (
+	"testing"
+)
+
+func TestFidelityLogin(t *testing.T) {
+	r := newReplay(t, "fidelity")
+	ctx := newTestBrowser(t, r)
+	auth := Auth{Username: "user", EncryptedPassword: "password"}
+	balances, err := fidelity{}.GetBalances(ctx, auth, testDecryptor(t), map[string]string{
+		"Individual": "Brokerage",
+		"ROTH IRA":   "Roth",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBalances(t, balances, map[string]int64{"Brokerage": 1234567, "Roth": 100000})
+	if got := r.submitted("/ftgw/digital/portfolio/summary", "username"); got != "user" {
+		t.Errorf("submitted username %q", got)
+	}
+}
+
+func TestFidelityTotp(t *testing.T) {
+	r := newReplay(t, "fidelity/mfa", "fidelity")
+	ctx := newTestBrowser(t, r)
+	auth := Auth{Username: "user", EncryptedPassword: "password", EncryptedTotpSecret: "JBSWY3DPEHPK3PXP"}
+	balances, err := fidelity{}.GetBalances(ctx, auth, testDecryptor(t), map[string]string{"Individual": "Brokerage"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBalances(t, balances, map[string]int64{"Brokerage": 1234567})
+	if got := r.submitted("/ftgw/digital/portfolio/summary", "code"); len(got) != 6 {
+		t.Errorf("submitted security code %q", got)
+	}
+}
@@ -0,0 +1,91 @@
+package institution
+
+import (
+	"context"
+	. "nw-updater/common"
+	"time"
+
+	"github.com/chromedp/chromedp"
+
+	"nw-updater/crypto"
+	"nw-updater/money"
+)
+
+const (
+	igoeLoginUrl  = "https://igoe.lh1ondemand.com/Login.aspx"
+	igoeUrlPrefix = "https://igoe.lh1ondemand.com"
+	// Account names to use in account_mappings for the HSA balances
+	IgoeHsaCash     = "HSA Cash"
+	IgoeHsaInvested = "HSA Invested"
+)
+
+// The selectors haven't been checked against pages captured from a real login yet. If balances aren't found,
+// run diagnose to see which one failed, and capture the pages to update them and the fixtures.
+const (
+	igoeSummarySelector  = "#hsaAccountSummary"
+	igoeQuestionSelector = "#lblSecurityQuestion"
+)
+
+var igoeSession = sessionSite{
+	Urls:             []string{igoeLoginUrl, igoeUrlPrefix + "/Member/Index.aspx"},
+	AccountsUrl:      igoeUrlPrefix + "/Member/Index.aspx",
+	AccountsSelector: igoeSummarySelector,
+}
+
+// igoe gets HSA balances from Igoe Administrative Services. The cash and invested balances are returned separately,
+// as IgoeHsaCash and IgoeHsaInvested, so they can be mapped to different accounts.
+type igoe struct {
+}
+
+func init() {
+	registerInstitution("igoe", igoe{})
+}
+
+func (i igoe) GetBalances(parentCtx context.Context, auth Auth, d crypto.OpenSslDecryptor,
+	mappings map[string]string) (map[string]AccountBalance, error) {
+
+	browserCtx, cancel, err := newContext(parentCtx, igoeUrlPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	if !restoreSession(browserCtx, d, igoeSession) {
+		err = i.login(browserCtx, auth, d)
+		if err != nil {
+			return nil, err
+		}
+	}
+	saveSession(browserCtx, d, igoeSession)
+	return getSelectorBalances(browserCtx, mappings, map[string]string{
+		IgoeHsaCash:     "#hsaCashBalance",
+		IgoeHsaInvested: "#hsaInvestedBalance",
+	}, money.US)
+}
+
+// login logs in to Igoe, answering a security question if one is asked.
+func (i igoe) login(parentCtx context.Context, auth Auth, d crypto.OpenSslDecryptor) error {
+	ctx, cancel := context.WithTimeout(parentCtx, 1*time.Minute)
+	defer cancel()
+	err := run(ctx,
+		chromedp.Navigate(siteUrl(ctx, igoeLoginUrl)),
+		chromedp.WaitReady("#USERNAME"),
+		capturePage(),
+		chromedp.SetValue("#USERNAME", auth.Username),
+		chromedp.SetValue("#PASSWORD", d.Decrypt(auth.EncryptedPassword)),
+		chromedp.Click("#btnLogin"),
+		chromedp.WaitVisible(igoeSummarySelector+", "+igoeQuestionSelector, chromedp.ByQuery),
+		capturePage())
+	if err != nil {
+		return screenshotError(parentCtx, err)
+	}
+	answered, err := answerQuestion(parentCtx, questionPage{
+		Question: igoeQuestionSelector,
+		Answer:   "#txtAnswer",
+		Submit:   "#btnContinue",
+	}, auth, d)
+	if err != nil || !answered {
+		return err
+	}
+	err = run(ctx, chromedp.WaitVisible(igoeSummarySelector), capturePage())
+	return screenshotError(parentCtx, err)
+}
@@ -0,0 +1,37 @@
+package institution
+
+import (
+	"testing"
+)
+
+func TestIgoeLogin(t *testing.T) {
+	r := newReplay(t, "igoe")
+	ctx := newTestBrowser(t, r)
+	auth := Auth{Username: "user", EncryptedPassword: "password"}
+	balances, err := igoe{}.GetBalances(ctx, auth, testDecryptor(t), map[string]string{
+		IgoeHsaCash:     "HSA",
+		IgoeHsaInvested: "HSA Investments",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBalances(t, balances, map[string]int64{"HSA": 123456, "HSA Investments": 789012})
+}
+
+func TestIgoeSecurityQuestion(t *testing.T) {
+	r := newReplay(t, "igoe/question", "igoe")
+	ctx := newTestBrowser(t, r)
+	auth := Auth{
+		Username:          "user",
+		EncryptedPassword: "password",
+		Questions:         map[string]string{"What was the name of your first pet": "Rex"},
+	}
+	balances, err := igoe{}.GetBalances(ctx, auth, testDecryptor(t), map[string]string{IgoeHsaCash: "HSA"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBalances(t, balances, map[string]int64{"HSA": 123456})
+	if got := r.submitted("/Member/Index.aspx", "answer"); got != "Rex" {
+		t.Errorf("submitted answer %q", got)
+	}
+}
@@ -0,0 +1,140 @@
+package institution
+
+import (
+	"context"
+	"errors"
+	. "nw-updater/common"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/chromedp"
+
+	"nw-updater/crypto"
+	"nw-updater/money"
+)
+
+const (
+	// the login form is on nb.fidelity.com, which redirects to workplaceservices.fidelity.com once logged in,
+	// so a tab on either site is reused
+	netBenefitsLoginUrl    = "https://nb.fidelity.com/public/nb/default/home"
+	netBenefitsLoginPrefix = "https://nb.fidelity.com"
+	netBenefitsUrlPrefix   = "https://workplaceservices.fidelity.com"
+	// the plan summary lists every plan for the login
+	netBenefitsPlanSelector = ".plan-card"
+	netBenefitsMfaHeading   = "To verify it's you, we'll send you a code"
+)
+
+var netBenefitsSession = sessionSite{
+	Urls:             []string{netBenefitsLoginUrl, netBenefitsUrlPrefix + "/mybenefits/navstation/Navigation"},
+	AccountsUrl:      netBenefitsUrlPrefix + "/mybenefits/navstation/Navigation",
+	AccountsSelector: netBenefitsPlanSelector,
+}
+
+// netBenefits gets balances for workplace plans like 401(k)s from Fidelity NetBenefits.
+// NetBenefits uses the same login form as Fidelity, but MFA sends a code by text message.
+type netBenefits struct {
+}
+
+func init() {
+	registerInstitution("netbenefits", netBenefits{})
+}
+
+func (n netBenefits) RequestCode(ctx context.Context, auth Auth, d crypto.OpenSslDecryptor) (context.Context, context.CancelFunc, error) {
+	// begin login process
+	doCancel := true
+	ctx, cancel, err := newContext(ctx, netBenefitsLoginPrefix, netBenefitsUrlPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if doCancel {
+			cancel()
+		}
+	}()
+	result, err := n.startAuth(ctx, auth.Username, d.Decrypt(auth.EncryptedPassword))
+	// ensure it asks for security code
+	switch result {
+	case LoginError:
+		return nil, nil, err
+	case LoginOk:
+		return nil, nil, errors.New("login successful, no security code needed")
+	case CodeRequired:
+		sendCtx, sendCancel := context.WithTimeout(ctx, 1*time.Minute)
+		defer sendCancel()
+		err = run(sendCtx,
+			chromedp.Click("#dom-channel-list-primary-button"),
+			chromedp.WaitVisible("#dom-otp-code-input"),
+			capturePage())
+		if err != nil {
+			return nil, nil, screenshotError(ctx, err)
+		}
+		// keep browser open! don't close context
+		doCancel = false
+		return ctx, cancel, nil
+	default:
+		return nil, nil, errors.New("unhandled auth result")
+	}
+}
+
+func (n netBenefits) EnterCode(parentCtx context.Context, code string) error {
+	ctx, cancel := context.WithTimeout(parentCtx, 1*time.Minute)
+	defer cancel()
+	// enter code into existing browser
+	err := run(ctx,
+		chromedp.SetValue("#dom-otp-code-input", code),
+		chromedp.Click("#dom-trust-device-checkbox"),
+		chromedp.Click("#dom-otp-code-submit-button"),
+		chromedp.WaitVisible(netBenefitsPlanSelector),
+		capturePage())
+	return screenshotError(parentCtx, err)
+}
+
+func (n netBenefits) GetBalances(parentCtx context.Context, auth Auth, d crypto.OpenSslDecryptor,
+	mappings map[string]string) (map[string]AccountBalance, error) {
+
+	browserCtx, cancel, err := newContext(parentCtx, netBenefitsLoginPrefix, netBenefitsUrlPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	result, screenshot := LoginOk, error(nil)
+	if !restoreSession(browserCtx, d, netBenefitsSession) {
+		result, screenshot = n.startAuth(browserCtx, auth.Username, d.Decrypt(auth.EncryptedPassword))
+	}
+	if result != LoginOk {
+		return nil, screenshot
+	}
+	ctx, cancel := context.WithTimeout(browserCtx, 1*time.Minute)
+	defer cancel()
+	var nodes []*cdp.Node
+	err = run(ctx, chromedp.Nodes(netBenefitsPlanSelector, &nodes, chromedp.ByQueryAll))
+	if err != nil {
+		return nil, screenshotError(browserCtx, err)
+	}
+	saveSession(browserCtx, d, netBenefitsSession)
+	return getMultipleBalances(nodes, browserCtx, mappings, ".plan-card__name", ".plan-card__balance",
+		money.US)
+}
+
+func (n netBenefits) startAuth(parentCtx context.Context, username, password string) (LoginResult, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, 1*time.Minute)
+	defer cancel()
+	var planNodes []*cdp.Node
+	err := run(ctx,
+		chromedp.Navigate(siteUrl(ctx, netBenefitsLoginUrl)),
+		chromedp.WaitReady("#dom-username-input"),
+		capturePage(),
+		chromedp.SetValue("#dom-username-input", username),
+		chromedp.SetValue("#dom-pswd-input", password),
+		chromedp.Click("#dom-login-button"),
+		chromedp.WaitVisible("//*[contains(@class,\"plan-card\")] | //h1[contains(.,\""+netBenefitsMfaHeading+"\")]"),
+		capturePage(),
+		chromedp.Nodes(netBenefitsPlanSelector, &planNodes, chromedp.AtLeast(0)))
+	if err != nil {
+		return LoginError, screenshotError(parentCtx, err)
+	}
+	if len(planNodes) == 0 {
+		return CodeRequired, screenshotError(parentCtx, ErrCodeRequired)
+	}
+	return LoginOk, errors.New("login ok")
+}
@@ -0,0 +1,45 @@
+package institution
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// fixedCode is a CodeProvider that always returns the same code.
+type fixedCode string
+
+func (f fixedCode) GetCode(context.Context, string, string) (string, error) {
+	return string(f), nil
+}
+
+func TestNetBenefitsLogin(t *testing.T) {
+	r := newReplay(t, "netbenefits")
+	ctx := newTestBrowser(t, r)
+	auth := Auth{Username: "user", EncryptedPassword: "password"}
+	balances, err := netBenefits{}.GetBalances(ctx, auth, testDecryptor(t), map[string]string{
+		"EXAMPLE CORP 401(K) PLAN": "401k",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBalances(t, balances, map[string]int64{"401k": 9876543})
+}
+
+func TestNetBenefitsCodeRequired(t *testing.T) {
+	r := newReplay(t, "netbenefits/mfa", "netbenefits")
+	ctx := newTestBrowser(t, r)
+	auth := Auth{Username: "user", EncryptedPassword: "password"}
+	d := testDecryptor(t)
+	_, err := netBenefits{}.GetBalances(ctx, auth, d, map[string]string{})
+	if !errors.Is(err, ErrCodeRequired) {
+		t.Fatalf("expected ErrCodeRequired, got %v", err)
+	}
+	err = CompleteMfa(ctx, netBenefits{}, "netbenefits", auth, d, fixedCode("123456"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.submitted("/mybenefits/navstation/Navigation", "code"); got != "123456" {
+		t.Errorf("submitted security code %q", got)
+	}
+}
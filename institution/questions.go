@@ -0,0 +1,158 @@
+package institution
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/chromedp"
+
+	"nw-updater/crypto"
+)
+
+// fillerWords are ignored when comparing the words of a configured and displayed question, since sites reword
+// questions like "What was the name of your first pet?" as "Name of first pet". Words that change the meaning of
+// a question, like "first" or "street", must never be added.
+var fillerWords = map[string]bool{
+	"a": true, "an": true, "the": true, "of": true, "in": true, "your": true, "you": true, "did": true,
+	"do": true, "is": true, "was": true, "what": true, "whats": true, "please": true, "enter": true,
+}
+
+// questionPage contains the selectors for a challenge question page.
+type questionPage struct {
+	Question string // Element containing the question text
+	Answer   string // Input to type the answer into
+	Submit   string // Button to submit the answer
+}
+
+// UnknownQuestionError is returned when a challenge question is displayed that doesn't match any configured question.
+type UnknownQuestionError struct {
+	Question string
+}
+
+func (e UnknownQuestionError) Error() string {
+	return fmt.Sprintf("no answer configured for security question '%s', add it to the questions in auth", e.Question)
+}
+
+// answerQuestion answers a challenge question if one is displayed, returning whether a question was answered.
+// The question is matched against the keys of auth.Questions with matchQuestion, and the answer is decrypted
+// with d before it is typed, so answers can be encrypted like passwords.
+func answerQuestion(parentCtx context.Context, page questionPage, auth Auth, d crypto.OpenSslDecryptor) (bool, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, 1*time.Minute)
+	defer cancel()
+	var nodes []*cdp.Node
+	err := run(ctx, chromedp.Nodes(page.Question, &nodes, chromedp.ByQuery, chromedp.AtLeast(0)))
+	if err != nil {
+		return false, screenshotError(parentCtx, err)
+	}
+	if len(nodes) == 0 {
+		return false, nil
+	}
+	var question string
+	err = run(ctx, chromedp.TextContent(page.Question, &question, chromedp.ByQuery))
+	if err != nil {
+		return false, screenshotError(parentCtx, err)
+	}
+	answer, err := matchQuestion(auth.Questions, question)
+	if err != nil {
+		return false, screenshotError(parentCtx, err)
+	}
+	err = run(ctx,
+		chromedp.SetValue(page.Answer, d.Decrypt(answer), chromedp.ByQuery),
+		chromedp.Click(page.Submit, chromedp.ByQuery))
+	if err != nil {
+		return false, screenshotError(parentCtx, err)
+	}
+	return true, nil
+}
+
+// matchQuestion returns the configured answer for a displayed question. Configured questions are tried as:
+//
+//  1. an exact match
+//  2. a case-insensitive match, ignoring punctuation and extra whitespace
+//  3. a regular expression, if the configured question is wrapped in slashes like "/first (pet|dog)/"
+//  4. the same words in the same order, ignoring fillerWords
+//
+// The first step that matches any question is used. An error is returned if no question matches, or if more
+// than one matches in that step.
+func matchQuestion(questions map[string]string, displayed string) (string, error) {
+	displayed = strings.TrimSpace(displayed)
+	if answer, ok := questions[displayed]; ok {
+		return answer, nil
+	}
+	normalized := normalizeQuestion(displayed)
+	var matches []string
+	for question, answer := range questions {
+		if normalizeQuestion(question) == normalized {
+			matches = append(matches, answer)
+		}
+	}
+	if len(matches) > 0 {
+		return oneMatch(matches, displayed)
+	}
+	for question, answer := range questions {
+		if len(question) > 2 && strings.HasPrefix(question, "/") && strings.HasSuffix(question, "/") {
+			r, err := regexp.Compile("(?i)" + question[1:len(question)-1])
+			if err != nil {
+				return "", fmt.Errorf("invalid security question pattern %s: %w", question, err)
+			}
+			if r.MatchString(displayed) {
+				matches = append(matches, answer)
+			}
+		}
+	}
+	if len(matches) > 0 {
+		return oneMatch(matches, displayed)
+	}
+	words := contentWords(normalized)
+	if words == "" {
+		return "", UnknownQuestionError{Question: displayed}
+	}
+	for question, answer := range questions {
+		if contentWords(normalizeQuestion(question)) == words {
+			matches = append(matches, answer)
+		}
+	}
+	if len(matches) > 0 {
+		return oneMatch(matches, displayed)
+	}
+	return "", UnknownQuestionError{Question: displayed}
+}
+
+// oneMatch returns the answer if there is only one in matches, since which of several configured questions a
+// displayed question meant can't be known.
+func oneMatch(matches []string, displayed string) (string, error) {
+	if len(matches) > 1 {
+		return "", fmt.Errorf("security question '%s' matches more than one configured question", displayed)
+	}
+	return matches[0], nil
+}
+
+// normalizeQuestion lowercases a question and removes punctuation and extra whitespace.
+func normalizeQuestion(question string) string {
+	cleaned := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		if r == '\'' || r == '’' {
+			return -1
+		}
+		return ' '
+	}, question)
+	return strings.Join(strings.Fields(cleaned), " ")
+}
+
+// contentWords returns a normalized question without its fillerWords.
+func contentWords(question string) string {
+	var words []string
+	for _, w := range strings.Fields(question) {
+		if !fillerWords[w] {
+			words = append(words, w)
+		}
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,93 @@
+package institution
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMatchQuestion(t *testing.T) {
+	questions := map[string]string{
+		"What was the name of your first pet?":  "pet",
+		"Who was your first grade teacher?":     "teacher",
+		"In what city were you born?":           "city",
+		"/mother'?s maiden name/":               "maiden",
+		"What is your favorite color?":          "color",
+		"What was your favourite colour?":       "colour",
+		"What street did you grow up on?":       "street",
+		"What street did your grandmother live": "grandmother",
+	}
+	tests := []struct {
+		displayed string
+		answer    string
+		unknown   bool // expect an UnknownQuestionError
+		wantErr   bool // expect another error
+	}{
+		{displayed: "What was the name of your first pet?", answer: "pet"},
+		{displayed: "  Who was your first grade teacher?\n", answer: "teacher"},
+		{displayed: "WHAT WAS THE NAME OF YOUR FIRST PET", answer: "pet"},
+		{displayed: "In what city were you born ?", answer: "city"},
+		{displayed: "What is your mothers maiden name?", answer: "maiden"},
+		{displayed: "Mother's Maiden Name", answer: "maiden"},
+		{displayed: "Name of first pet", answer: "pet"},
+		{displayed: "What is the name of your first pet?", answer: "pet"},
+		{displayed: "What is your favorite colour?", unknown: true},
+		{displayed: "Who was your second grade teacher?", unknown: true},
+		{displayed: "What was the name of your second pet?", unknown: true},
+		{displayed: "What was the name of your first dog?", unknown: true},
+		{displayed: "In what city was your father born?", unknown: true},
+		{displayed: "What street did you live on?", unknown: true},
+		{displayed: "What is the?", unknown: true},
+		{displayed: "", unknown: true},
+	}
+	for _, test := range tests {
+		answer, err := matchQuestion(questions, test.displayed)
+		if test.unknown {
+			if _, ok := errors.AsType[UnknownQuestionError](err); !ok {
+				t.Errorf("matchQuestion(%q) = %q, %v, expected an UnknownQuestionError", test.displayed, answer, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("matchQuestion(%q) returned error: %s", test.displayed, err)
+		} else if answer != test.answer {
+			t.Errorf("matchQuestion(%q) = %q, expected %q", test.displayed, answer, test.answer)
+		}
+	}
+}
+
+func TestMatchQuestionErrors(t *testing.T) {
+	if _, err := matchQuestion(map[string]string{"/first (pet/": "pet"}, "Name of first pet?"); err == nil {
+		t.Error("matchQuestion should return an error for an invalid pattern")
+	}
+	tests := []struct {
+		questions map[string]string
+		displayed string
+	}{
+		// by words
+		{questions: map[string]string{"What is your first pet?": "a", "What was your first pet?": "b"},
+			displayed: "Your first pet"},
+		// ignoring case and punctuation
+		{questions: map[string]string{"First pet?": "a", "first pet": "b"}, displayed: "FIRST PET"},
+		// by pattern
+		{questions: map[string]string{"/first pet/": "a", "/pet/": "b"}, displayed: "Name of your first pet"},
+	}
+	for _, test := range tests {
+		// the questions are in a map, so check that neither is picked by the order it's iterated in
+		for range 10 {
+			answer, err := matchQuestion(test.questions, test.displayed)
+			if err == nil {
+				t.Errorf("matchQuestion(%q) = %q, expected an error since more than one question matches",
+					test.displayed, answer)
+				break
+			} else if _, ok := errors.AsType[UnknownQuestionError](err); ok {
+				t.Errorf("matchQuestion(%q) returned %v, expected an ambiguous match error", test.displayed, err)
+				break
+			}
+		}
+	}
+	// an earlier step matching only one question is used, even if a later step would match more
+	questions := map[string]string{"What was your first pet?": "a", "/pet/": "b"}
+	if answer, err := matchQuestion(questions, "what was your first pet"); err != nil || answer != "a" {
+		t.Errorf("matchQuestion = %q, %v, expected the case-insensitive match", answer, err)
+	}
+}
@@ -0,0 +1,126 @@
+package institution
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+)
+
+type baseUrlKey struct{}
+
+// WithBaseUrl returns a context that makes institutions load every page from base instead of their real site,
+// keeping the path, so they can be run against saved pages served locally.
+func WithBaseUrl(ctx context.Context, base string) context.Context {
+	return context.WithValue(ctx, baseUrlKey{}, strings.TrimSuffix(base, "/"))
+}
+
+// siteUrl returns u, or u on the base URL from WithBaseUrl if there is one.
+func siteUrl(ctx context.Context, u string) string {
+	base, ok := ctx.Value(baseUrlKey{}).(string)
+	if !ok || u == "" {
+		return u
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	parsed.Scheme, parsed.Host = "", ""
+	return base + parsed.String()
+}
+
+type captureKey struct{}
+
+// capture is where to save pages, and the text to redact from them.
+type capture struct {
+	dir    string
+	redact []string
+}
+
+// WithCapture returns a context that makes institutions save a sanitized copy of each page they use to dir,
+// so it can be used as a fixture for testing. Pages are saved at their URL path with .html added, or .2.html
+// and so on if that file already exists. Scripts, frames, event handlers and input values are
+// removed, along with any redact strings like the username, but account names and balances are kept, so
+// review the pages before sharing them.
+func WithCapture(ctx context.Context, dir string, redact ...string) context.Context {
+	return context.WithValue(ctx, captureKey{}, capture{dir: dir, redact: redact})
+}
+
+// sanitizeScript returns the current page without anything that could run or contain entered values.
+const sanitizeScript = `(() => {
+	const doc = document.documentElement.cloneNode(true);
+	doc.querySelectorAll('script, iframe, noscript').forEach(e => e.remove());
+	doc.querySelectorAll('input, textarea').forEach(e => {
+		e.removeAttribute('value');
+		e.textContent = '';
+	});
+	doc.querySelectorAll('*').forEach(e => {
+		for (const a of [...e.attributes]) {
+			if (a.name.startsWith('on')) e.removeAttribute(a.name);
+		}
+	});
+	return {url: location.href, html: '<!DOCTYPE html>\n' + doc.outerHTML};
+})()`
+
+// capturePage saves the current page if capturing was enabled with WithCapture. Failures are printed rather than
+// returned, so capturing never stops an institution from getting balances.
+func capturePage() chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		c, ok := ctx.Value(captureKey{}).(capture)
+		if !ok {
+			return nil
+		}
+		var page struct {
+			Url  string `json:"url"`
+			Html string `json:"html"`
+		}
+		err := chromedp.Evaluate(sanitizeScript, &page).Do(ctx)
+		if err == nil {
+			err = c.save(page.Url, page.Html)
+		}
+		if err != nil {
+			fmt.Printf("Failed to capture page: %s\n", err)
+		}
+		return nil
+	})
+}
+
+// save writes html to the file for pageUrl, without overwriting a page saved earlier.
+func (c capture) save(pageUrl, html string) error {
+	for _, r := range c.redact {
+		if r != "" {
+			html = strings.ReplaceAll(html, r, "REDACTED")
+		}
+	}
+	u, err := url.Parse(pageUrl)
+	if err != nil {
+		return err
+	}
+	name := path.Clean("/" + u.Path)
+	if name == "/" {
+		name = "/index"
+	}
+	file := filepath.Join(c.dir, filepath.FromSlash(name))
+	err = os.MkdirAll(filepath.Dir(file), 0700)
+	if err != nil {
+		return err
+	}
+	target := file + ".html"
+	for i := 2; ; i++ {
+		_, err = os.Stat(target)
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		target = fmt.Sprintf("%s.%d.html", file, i)
+	}
+	// the query isn't printed, since it could contain form values
+	fmt.Printf("Captured %s to %s\n", u.Path, target)
+	return os.WriteFile(target, []byte(html), 0600)
+}
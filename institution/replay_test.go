@@ -0,0 +1,135 @@
+package institution
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	. "nw-updater/common"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chromedp/chromedp"
+
+	"nw-updater/crypto"
+)
+
+// replay serves saved pages from testdata, like the ones saved by the capture command, and records the URLs
+// requested so tests can check what was submitted in forms.
+type replay struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*url.URL
+}
+
+// newReplay serves the pages in each dir under testdata, trying them in order. A page for /a/b is read from
+// a/b.html, so scenarios can put only the pages that differ in their own dir before the institution's dir.
+func newReplay(t *testing.T, dirs ...string) *replay {
+	r := &replay{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		r.requests = append(r.requests, req.URL)
+		r.mu.Unlock()
+		name := path.Clean("/" + req.URL.Path)
+		if name == "/" {
+			name = "/index"
+		}
+		for _, dir := range dirs {
+			file := filepath.Join("testdata", dir, filepath.FromSlash(name)+".html")
+			if page, err := os.ReadFile(file); err == nil {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				_, _ = w.Write(deferBody(page))
+				return
+			}
+		}
+		http.NotFound(w, req)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// bodyPattern matches the content of a page's body.
+var bodyPattern = regexp.MustCompile(`(?is)(<body[^>]*>)(.*)(</body>)`)
+
+// deferBody moves a page's body into a template that is only added to the page on DOMContentLoaded, like the
+// pages of real sites that are rendered by scripts. Chrome replaces the document again at DOMContentLoaded,
+// invalidating the nodes chromedp has found, so a static page that can be used before then makes tests flaky.
+func deferBody(page []byte) []byte {
+	return bodyPattern.ReplaceAll(page, []byte(`$1<template id="replay-body">$2</template><script>`+
+		`document.addEventListener("DOMContentLoaded", () => `+
+		`document.body.replaceChildren(document.getElementById("replay-body").content))</script>$3`))
+}
+
+// submitted returns the value of a form field in the first request for pagePath that included it.
+func (r *replay) submitted(pagePath, field string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range r.requests {
+		if u.Path == pagePath && u.Query().Has(field) {
+			return u.Query().Get(field)
+		}
+	}
+	return ""
+}
+
+// newTestBrowser starts headless Chrome for a test, and returns a context that loads institution pages from
+// the replay server. Tests are skipped if Chrome can't be found, set CHROME_PATH to choose a binary.
+func newTestBrowser(t *testing.T, r *replay) context.Context {
+	chrome := os.Getenv("CHROME_PATH")
+	for _, name := range []string{"google-chrome", "google-chrome-stable", "chromium", "chromium-browser"} {
+		if chrome != "" {
+			break
+		}
+		chrome, _ = exec.LookPath(name)
+	}
+	if chrome == "" {
+		// CI installs Chrome, so a missing browser there is a broken build rather than a reason to skip
+		if os.Getenv("CI") != "" {
+			t.Fatal("Chrome not found in CI, set CHROME_PATH")
+		}
+		t.Skip("Chrome not found, set CHROME_PATH to run replay tests")
+	}
+	opts := append(slices.Clone(chromedp.DefaultExecAllocatorOptions[:]), chromedp.ExecPath(chrome), chromedp.NoSandbox)
+	allocCtx, cancel1 := chromedp.NewExecAllocator(context.Background(), opts...)
+	ctx, cancel2 := chromedp.NewContext(allocCtx)
+	ctx, cancel3 := context.WithTimeout(ctx, 1*time.Minute)
+	t.Cleanup(func() {
+		cancel3()
+		cancel2()
+		cancel1()
+	})
+	if err := chromedp.Run(ctx); err != nil {
+		t.Fatalf("failed to start Chrome: %s", err)
+	}
+	return WithBaseUrl(ctx, r.URL)
+}
+
+// testDecryptor returns a decryptor for tests. Values that aren't encrypted are returned as is, so tests
+// can use plain passwords.
+func testDecryptor(t *testing.T) crypto.OpenSslDecryptor {
+	file := filepath.Join(t.TempDir(), "passphrase")
+	if err := os.WriteFile(file, []byte("test passphrase"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return crypto.NewOpenSslDecryptor(file)
+}
+
+// checkBalances fails the test unless balances has exactly the expected cents for each account.
+func checkBalances(t *testing.T, balances map[string]AccountBalance, expected map[string]int64) {
+	t.Helper()
+	if len(balances) != len(expected) {
+		t.Errorf("got %d balances, expected %d: %v", len(balances), len(expected), balances)
+	}
+	for account, cents := range expected {
+		if got, ok := balances[account]; !ok || got.Balance != cents {
+			t.Errorf("balance of %s is %d, expected %d", account, got.Balance, cents)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package institution
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	. "nw-updater/common"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/chromedp"
+
+	"nw-updater/crypto"
+	"nw-updater/money"
+)
+
+// Script defines the steps to log in to an institution and find its balances, so that simple sites can be supported
+// from the config file without writing an Institution. All selectors are CSS selectors.
+type Script struct {
+	LoginUrl string `yaml:"login_url"`
+	// Existing tabs starting with this are reused when connected with --websocket. Defaults to the login URL's origin.
+	UrlPrefix        string `yaml:"url_prefix,omitempty"`
+	UsernameSelector string `yaml:"username_selector"`
+	PasswordSelector string `yaml:"password_selector"`
+	SubmitSelector   string `yaml:"submit_selector"`
+	// Visible once logged in. Defaults to row_selector.
+	WaitSelector string `yaml:"wait_selector,omitempty"`
+	// Visible instead of wait_selector when a security code is needed.
+	MfaSelector string `yaml:"mfa_selector,omitempty"`
+	// Where to enter the security code, from encrypted_totp_secret or the security-code command.
+	CodeSelector       string `yaml:"code_selector,omitempty"`
+	CodeSubmitSelector string `yaml:"code_submit_selector,omitempty"`
+	// Visible instead of wait_selector when a security question is asked, answered from the questions in auth.
+	QuestionSelector     string `yaml:"question_selector,omitempty"`
+	AnswerSelector       string `yaml:"answer_selector,omitempty"`
+	AnswerSubmitSelector string `yaml:"answer_submit_selector,omitempty"`
+	// If the balances aren't on the page shown after logging in, the page to go to for them. Setting this also lets
+	// sessions_dir skip logging in.
+	AccountsUrl string `yaml:"accounts_url,omitempty"`
+	// Each account's row, and the name and balance inside each row.
+	RowSelector     string `yaml:"row_selector"`
+	NameSelector    string `yaml:"name_selector"`
+	BalanceSelector string `yaml:"balance_selector"`
+	// How balances are formatted, "us" for 1,234.56 or "eu" for 1.234,56. Defaults to us.
+	Locale string `yaml:"locale,omitempty"`
+}
+
+// scripted is an Institution that follows a Script.
+type scripted struct {
+	script Script
+	locale money.Locale
+}
+
+// RegisterScripted validates a Script from the config and registers it as an institution called name.
+func RegisterScripted(name string, script Script) error {
+	if _, ok := institutions[name]; ok {
+		return fmt.Errorf("scripted institution '%s' has the same name as another institution", name)
+	}
+	required := []struct{ field, value string }{
+		{"login_url", script.LoginUrl},
+		{"username_selector", script.UsernameSelector},
+		{"password_selector", script.PasswordSelector},
+		{"submit_selector", script.SubmitSelector},
+		{"row_selector", script.RowSelector},
+		{"name_selector", script.NameSelector},
+		{"balance_selector", script.BalanceSelector},
+	}
+	for _, r := range required {
+		if r.value == "" {
+			return fmt.Errorf("scripted institution '%s' is missing %s", name, r.field)
+		}
+	}
+	if (script.CodeSelector == "") != (script.CodeSubmitSelector == "") {
+		return fmt.Errorf("scripted institution '%s' needs both code_selector and code_submit_selector", name)
+	}
+	if script.QuestionSelector != "" && (script.AnswerSelector == "" || script.AnswerSubmitSelector == "") {
+		return fmt.Errorf("scripted institution '%s' needs answer_selector and answer_submit_selector", name)
+	}
+	if script.UrlPrefix == "" {
+		u, err := url.Parse(script.LoginUrl)
+		if err != nil {
+			return fmt.Errorf("scripted institution '%s' has an invalid login_url: %w", name, err)
+		}
+		script.UrlPrefix = u.Scheme + "://" + u.Host
+	}
+	if script.WaitSelector == "" {
+		script.WaitSelector = script.RowSelector
+	}
+	locale := money.US
+	if script.Locale != "" {
+		var err error
+		locale, err = money.LocaleByName(script.Locale)
+		if err != nil {
+			return fmt.Errorf("scripted institution '%s': %w", name, err)
+		}
+	}
+	registerInstitution(name, scripted{script: script, locale: locale})
+	return nil
+}
+
+// session returns how to restore a session for the script, and false if it doesn't have an accounts page to check.
+func (s scripted) session() (sessionSite, bool) {
+	return sessionSite{
+		Urls:             []string{s.script.LoginUrl, s.script.AccountsUrl},
+		AccountsUrl:      s.script.AccountsUrl,
+		AccountsSelector: s.script.RowSelector,
+	}, s.script.AccountsUrl != ""
+}
+
+func (s scripted) RequestCode(ctx context.Context, auth Auth, d crypto.OpenSslDecryptor) (context.Context, context.CancelFunc, error) {
+	if s.script.CodeSelector == "" {
+		return nil, nil, errors.New("security codes are not supported, add code_selector to the script")
+	}
+	// begin login process
+	doCancel := true
+	ctx, cancel, err := newContext(ctx, s.script.UrlPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if doCancel {
+			cancel()
+		}
+	}()
+	result, err := s.startAuth(ctx, auth, d)
+	// ensure it asks for security code
+	switch result {
+	case LoginError:
+		return nil, nil, err
+	case LoginOk:
+		return nil, nil, errors.New("login successful, no security code needed")
+	case CodeRequired:
+		// keep browser open! don't close context
+		doCancel = false
+		return ctx, cancel, nil
+	default:
+		return nil, nil, errors.New("unhandled auth result")
+	}
+}
+
+func (s scripted) EnterCode(parentCtx context.Context, code string) error {
+	ctx, cancel := context.WithTimeout(parentCtx, 1*time.Minute)
+	defer cancel()
+	// enter code into existing browser
+	err := run(ctx,
+		chromedp.SetValue(s.script.CodeSelector, code, chromedp.ByQuery),
+		chromedp.Click(s.script.CodeSubmitSelector, chromedp.ByQuery),
+		chromedp.WaitVisible(s.script.WaitSelector, chromedp.ByQuery),
+		capturePage())
+	return screenshotError(parentCtx, err)
+}
+
+func (s scripted) GetBalances(parentCtx context.Context, auth Auth, d crypto.OpenSslDecryptor,
+	mappings map[string]string) (map[string]AccountBalance, error) {
+
+	browserCtx, cancel, err := newContext(parentCtx, s.script.UrlPrefix)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	site, canRestore := s.session()
+	restored := canRestore && restoreSession(browserCtx, d, site)
+	if !restored {
+		result, screenshot := s.startAuth(browserCtx, auth, d)
+		if result == CodeRequired && s.script.CodeSelector != "" {
+			// complete MFA unattended if there is a TOTP secret
+			code, ok, err := totpCode(browserCtx, auth, d)
+			if err != nil {
+				return nil, fmt.Errorf("failed to generate security code: %w", err)
+			}
+			if ok {
+				err = s.EnterCode(browserCtx, code)
+				if err != nil {
+					return nil, err
+				}
+				result = LoginOk
+			}
+		}
+		if result != LoginOk {
+			return nil, screenshot
+		}
+	}
+	ctx, cancel := context.WithTimeout(browserCtx, 1*time.Minute)
+	defer cancel()
+	if s.script.AccountsUrl != "" && !restored {
+		err = run(ctx,
+			chromedp.Navigate(siteUrl(ctx, s.script.AccountsUrl)),
+			chromedp.WaitReady(s.script.RowSelector, chromedp.ByQuery),
+			capturePage())
+		if err != nil {
+			return nil, screenshotError(browserCtx, err)
+		}
+	}
+	var nodes []*cdp.Node
+	err = run(ctx, chromedp.Nodes(s.script.RowSelector, &nodes, chromedp.ByQueryAll))
+	if err != nil {
+		return nil, screenshotError(browserCtx, err)
+	}
+	if canRestore {
+		saveSession(browserCtx, d, site)
+	}
+	return getMultipleBalances(nodes, browserCtx, mappings, s.script.NameSelector, s.script.BalanceSelector,
+		s.locale)
+}
+
+// startAuth fills in the login form and waits until it is logged in, or a security code or question is needed.
+// Security questions are answered before returning.
+func (s scripted) startAuth(parentCtx context.Context, auth Auth, d crypto.OpenSslDecryptor) (LoginResult, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, 1*time.Minute)
+	defer cancel()
+	// done is visible once the login form and any security question are finished
+	done := s.script.WaitSelector
+	if s.script.MfaSelector != "" {
+		done += ", " + s.script.MfaSelector
+	}
+	wait := done
+	if s.script.QuestionSelector != "" {
+		wait += ", " + s.script.QuestionSelector
+	}
+	err := run(ctx,
+		chromedp.Navigate(siteUrl(ctx, s.script.LoginUrl)),
+		chromedp.WaitReady(s.script.UsernameSelector, chromedp.ByQuery),
+		capturePage(),
+		chromedp.SetValue(s.script.UsernameSelector, auth.Username, chromedp.ByQuery),
+		chromedp.SetValue(s.script.PasswordSelector, d.Decrypt(auth.EncryptedPassword), chromedp.ByQuery),
+		chromedp.Click(s.script.SubmitSelector, chromedp.ByQuery),
+		chromedp.WaitVisible(wait, chromedp.ByQuery),
+		capturePage())
+	if err != nil {
+		return LoginError, screenshotError(parentCtx, err)
+	}
+	if s.script.QuestionSelector != "" {
+		answered, err := answerQuestion(parentCtx, questionPage{
+			Question: s.script.QuestionSelector,
+			Answer:   s.script.AnswerSelector,
+			Submit:   s.script.AnswerSubmitSelector,
+		}, auth, d)
+		if err != nil {
+			return LoginError, err
+		}
+		if answered {
+			err = run(ctx, chromedp.WaitVisible(done, chromedp.ByQuery), capturePage())
+			if err != nil {
+				return LoginError, screenshotError(parentCtx, err)
+			}
+		}
+	}
+	if s.script.MfaSelector != "" {
+		var mfaNodes []*cdp.Node
+		err = run(ctx, chromedp.Nodes(s.script.MfaSelector, &mfaNodes, chromedp.ByQuery, chromedp.AtLeast(0)))
+		if err != nil {
+			return LoginError, screenshotError(parentCtx, err)
+		}
+		if len(mfaNodes) > 0 {
+			return CodeRequired, screenshotError(parentCtx, ErrCodeRequired)
+		}
+	}
+	return LoginOk, errors.New("login ok")
+}
@@ -0,0 +1,47 @@
+package institution
+
+import (
+	"strings"
+	"testing"
+)
+
+var testScript = Script{
+	LoginUrl:         "https://online.example-cu.org/login",
+	UsernameSelector: "#user",
+	PasswordSelector: "#pass",
+	SubmitSelector:   "button.sign-in",
+	RowSelector:      "tr.account",
+	NameSelector:     ".name",
+	BalanceSelector:  ".balance",
+}
+
+func TestRegisterScriptedRequiresSelectors(t *testing.T) {
+	script := testScript
+	script.BalanceSelector = ""
+	err := RegisterScripted("incomplete-credit-union", script)
+	if err == nil || !strings.Contains(err.Error(), "balance_selector") {
+		t.Errorf("expected missing balance_selector error, got %v", err)
+	}
+	err = RegisterScripted("fidelity", testScript)
+	if err == nil {
+		t.Error("expected error registering over a built in institution")
+	}
+}
+
+func TestScriptedLogin(t *testing.T) {
+	if err := RegisterScripted("example-credit-union", testScript); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { delete(institutions, "example-credit-union") })
+	r := newReplay(t, "scripted")
+	ctx := newTestBrowser(t, r)
+	auth := Auth{Username: "user", EncryptedPassword: "password"}
+	balances, err := MustGet("example-credit-union").GetBalances(ctx, auth, testDecryptor(t), map[string]string{
+		"Share Savings": "Savings",
+		"Checking":      "Checking",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBalances(t, balances, map[string]int64{"Savings": 500, "Checking": -1234})
+}
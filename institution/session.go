@@ -0,0 +1,216 @@
+package institution
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+
+	"nw-updater/crypto"
+)
+
+type sessionFileKey struct{}
+
+// WithSessionFile returns a context that makes institutions save their session to path after getting balances,
+// and try to restore it before logging in next time.
+func WithSessionFile(ctx context.Context, path string) context.Context {
+	return context.WithValue(ctx, sessionFileKey{}, path)
+}
+
+// sessionSite describes how to save and restore an institution's session.
+type sessionSite struct {
+	Urls             []string // Cookies for these URLs are saved
+	AccountsUrl      string   // The page to go straight to with a restored session
+	AccountsSelector string   // Only visible on AccountsUrl when logged in
+}
+
+// savedSession is the cookies and localStorage of an institution session, stored encrypted in the session file.
+type savedSession struct {
+	Saved        time.Time
+	Cookies      []*network.Cookie
+	LocalStorage map[string]map[string]string // origin to items
+}
+
+// restoreSession loads the saved session into the browser and opens the accounts page, returning true if the
+// session is still logged in. Any problem is printed rather than returned, since the institution can log in instead.
+func restoreSession(parentCtx context.Context, d crypto.OpenSslDecryptor, site sessionSite) bool {
+	path, ok := parentCtx.Value(sessionFileKey{}).(string)
+	if !ok {
+		return false
+	}
+	session, err := readSession(path, d)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false
+	} else if err != nil {
+		fmt.Printf("Failed to read saved session, logging in: %s\n", err)
+		return false
+	}
+	cookies := make([]*network.CookieParam, 0, len(session.Cookies))
+	now := time.Now()
+	for _, c := range session.Cookies {
+		param := &network.CookieParam{
+			Name:         c.Name,
+			Value:        c.Value,
+			Domain:       c.Domain,
+			Path:         c.Path,
+			Secure:       c.Secure,
+			HTTPOnly:     c.HTTPOnly,
+			SameSite:     c.SameSite,
+			Priority:     c.Priority,
+			SourceScheme: c.SourceScheme,
+			SourcePort:   c.SourcePort,
+			PartitionKey: c.PartitionKey,
+		}
+		if !c.Session {
+			expires := time.Unix(int64(c.Expires), 0)
+			if expires.Before(now) {
+				continue
+			}
+			param.Expires = new(cdp.TimeSinceEpoch(expires))
+		}
+		cookies = append(cookies, param)
+	}
+	storage, err := json.Marshal(session.LocalStorage)
+	if err != nil {
+		fmt.Printf("Failed to restore saved session, logging in: %s\n", err)
+		return false
+	}
+	// localStorage can only be set from a page on the same origin, so set it before the page's own scripts run
+	script := fmt.Sprintf(`(() => {
+	const items = %s[location.origin] || {};
+	for (const [key, value] of Object.entries(items)) {
+		if (localStorage.getItem(key) === null) localStorage.setItem(key, value);
+	}
+})()`, storage)
+
+	ctx, cancel := context.WithTimeout(parentCtx, 30*time.Second)
+	defer cancel()
+	var scriptId page.ScriptIdentifier
+	err = run(ctx,
+		network.SetCookies(cookies),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			var err error
+			scriptId, err = page.AddScriptToEvaluateOnNewDocument(script).Do(ctx)
+			return err
+		}),
+		chromedp.Navigate(siteUrl(ctx, site.AccountsUrl)),
+		chromedp.WaitVisible(site.AccountsSelector, chromedp.ByQuery))
+	// the items are in localStorage by now, and later pages shouldn't get them back after logging out
+	if scriptId != "" {
+		if err := run(parentCtx, page.RemoveScriptToEvaluateOnNewDocument(scriptId)); err != nil {
+			fmt.Printf("Failed to remove session restore script: %s\n", err)
+		}
+	}
+	if err != nil {
+		fmt.Println("Saved session is no longer valid, logging in")
+		clearSession(parentCtx, session)
+		return false
+	}
+	fmt.Println("Restored saved session")
+	return true
+}
+
+// clearSession removes the cookies and localStorage items of a session that couldn't be restored, so logging in
+// starts without them. Only the session's own cookies are deleted, since the browser may be shared with other
+// institutions.
+func clearSession(parentCtx context.Context, session savedSession) {
+	ctx, cancel := context.WithTimeout(parentCtx, 30*time.Second)
+	defer cancel()
+	actions := make([]chromedp.Action, 0, len(session.Cookies)+1)
+	for _, c := range session.Cookies {
+		actions = append(actions, network.DeleteCookies(c.Name).WithDomain(c.Domain).WithPath(c.Path))
+	}
+	keys := make(map[string][]string)
+	for origin, items := range session.LocalStorage {
+		for key := range items {
+			keys[origin] = append(keys[origin], key)
+		}
+	}
+	if encoded, err := json.Marshal(keys); err == nil {
+		actions = append(actions, chromedp.Evaluate(fmt.Sprintf(
+			`for (const key of %s[location.origin] || []) localStorage.removeItem(key)`, encoded), nil))
+	}
+	if err := run(ctx, actions...); err != nil {
+		fmt.Printf("Failed to clear saved session: %s\n", err)
+	}
+}
+
+// saveSession saves the cookies and localStorage of a logged in institution, so restoreSession can skip logging in
+// on the next run. Any problem is printed rather than returned, since the balances were already found.
+func saveSession(parentCtx context.Context, d crypto.OpenSslDecryptor, site sessionSite) {
+	path, ok := parentCtx.Value(sessionFileKey{}).(string)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(parentCtx, 30*time.Second)
+	defer cancel()
+	session := savedSession{Saved: time.Now()}
+	urls := make([]string, 0, len(site.Urls))
+	for _, u := range site.Urls {
+		urls = append(urls, siteUrl(ctx, u))
+	}
+	var origin string
+	var items map[string]string
+	err := run(ctx,
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			var err error
+			session.Cookies, err = network.GetCookies().WithURLs(urls).Do(ctx)
+			return err
+		}),
+		chromedp.Evaluate(`location.origin`, &origin),
+		chromedp.Evaluate(`Object.fromEntries(Object.entries(localStorage))`, &items))
+	if err == nil {
+		session.LocalStorage = map[string]map[string]string{origin: items}
+		err = writeSession(path, d, session)
+	}
+	if err != nil {
+		fmt.Printf("Failed to save session: %s\n", err)
+	}
+}
+
+// readSession decrypts and decodes the session file at path.
+func readSession(path string, d crypto.OpenSslDecryptor) (savedSession, error) {
+	var session savedSession
+	encrypted, err := os.ReadFile(path)
+	if err != nil {
+		return session, err
+	}
+	decrypted, err := d.Open(encrypted)
+	if err != nil {
+		return session, err
+	}
+	err = json.Unmarshal(decrypted, &session)
+	return session, err
+}
+
+// writeSession encrypts session and writes it to path, readable only by the current user.
+func writeSession(path string, d crypto.OpenSslDecryptor, session savedSession) error {
+	decrypted, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	encrypted, err := d.Seal(decrypted)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	// write then rename, so a failed write never leaves a corrupt session behind
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, encrypted, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
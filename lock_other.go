@@ -0,0 +1,36 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// FileLock is an exclusive lock on a file, held until Unlock is called.
+// Without flock, the lock is the existence of the file, so a crashed run leaves a stale lock file behind.
+type FileLock struct {
+	path string
+}
+
+// ErrLocked is returned by Lock when another process holds the lock.
+var ErrLocked = errors.New("lock is held by another run")
+
+// Lock takes an exclusive lock on path without blocking, returning ErrLocked if another process holds it.
+func Lock(path string) (*FileLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return nil, ErrLocked
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating lock file: %w", err)
+	}
+	f.Close()
+	return &FileLock{path: path}, nil
+}
+
+// Unlock releases the lock.
+func (l *FileLock) Unlock() error {
+	return os.Remove(l.path)
+}
@@ -25,10 +25,24 @@ Flags:
 	--websocket url
 		Use an existing Chrome DevTools instance.
 
+	--dry-run
+		Get balances and print the adjustments that would be made, without creating any transactions.
+
+	--force
+		Create adjustments even if they violate the guardrails in the config file.
+
 Commands:
 
+	plan
+		Same as --dry-run: print a table of account, current balance, new balance and difference.
+		Args:
+			none
+
 	security-code
-		Complete MFA code entry for institution logins that require it.
+		Complete MFA code entry for institution logins that require it. Not needed for logins with an
+		encrypted_totp_secret, which generate the code themselves. If mfa is configured, a link to enter the
+		code is sent by email or webhook instead of prompting on the terminal, and the same happens whenever
+		a login needs a code during a normal run.
 		Args:
 			--institution name  Institution key from config (required)
 			--username value    Username from config
@@ -39,8 +53,63 @@ Commands:
 		Args:
 			--token value  SimpleFin setup token (required)
 
+	history
+		Query the balance history recorded by each run, printing a table or exporting CSV.
+		Args:
+			--account name  Destination account name or id (optional)
+			--from date     Only balances on or after this date, YYYY-MM-DD (optional)
+			--to date       Only balances on or before this date, YYYY-MM-DD (optional)
+			--csv file      Write CSV to this file, or - for stdout (optional)
+
+	daemon (or serve)
+		Keep running, updating balances on the schedules in the daemon section of the config file, and reusing
+		the same browser for every run. Runs hold the lock_file so they never overlap. On SIGTERM, the account
+		update in progress is finished before exiting. If daemon.http_address is set, a status dashboard is
+		served on it, along with a JSON API under /api, POST /api/sync (with Content-Type: application/json)
+		to start a sync immediately, and Prometheus metrics on /metrics. daemon.encrypted_http_password is
+		required unless http_address is a loopback address. Metrics are also written to metrics.textfile after
+		each run, in daemon mode or not, adding to the counters already in it.
+		Args:
+			none
+
+	diagnose
+		Log in to an institution, printing each browser step with its selector, how long it took and whether it
+		failed, then print the balances found. Failures are saved to failures_dir, or ./failures if it isn't set,
+		with a screenshot, the page's HTML and its console errors.
+		Args:
+			--institution name  Institution key from config (required)
+			--username value    Username from config (optional, needed if there are multiple accounts at this institution)
+
+	capture
+		Log in to an institution and save a sanitized copy of each page it uses, to use as test fixtures. Scripts,
+		input values and the username are removed, but account names and balances are kept, so review the pages
+		before sharing them.
+		Args:
+			--institution name  Institution key from config (required)
+			--username value    Username from config (optional, needed if there are multiple accounts at this institution)
+			--dir dir           Directory to save pages in, at their URL paths (required)
+
+	profiles
+		List or wipe the Chrome profiles kept in profiles_dir for each institution login. When profiles_dir is
+		set, each login runs in its own browser using its profile, so cookies like trusted device tokens are
+		kept between runs.
+		Args:
+			list                 Print each login's profile directory, size and last use, plus unused profiles
+			wipe
+			  --institution name Wipe the profiles for this institution (optional)
+			  --username value   Wipe the profiles for this username (optional)
+			  --all              Wipe every profile, including ones not in the config (optional)
+
 	setup
-		Interactively map SimpleFin accounts to Actual Budget accounts and write mapping.yaml.
+		Interactively map SimpleFin accounts to Actual Budget accounts and write mapping.yaml. Accounts are
+		mapped by id, so they keep syncing when renamed.
+		Args:
+			none
+
+	validate
+		Check that each account_mappings value matches exactly one account in each destination, by id or else
+		by name, warning about missing, duplicate named, closed and on-budget accounts, and accounts matched by
+		name rather than id.
 		Args:
 			none
 
@@ -53,12 +122,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"maps"
 	. "nw-updater/common"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/chromedp/chromedp"
@@ -78,12 +149,38 @@ type Config struct {
 	YnabConfig        *YnabConfig         `yaml:"ynab"`
 	ActualConfig      *ActualBudgetConfig `yaml:"actual"`
 	EmailConfig       EmailConfig         `yaml:"email"`
+	History           *HistoryConfig      `yaml:"history,omitempty"`
+	Guardrails        *GuardrailConfig    `yaml:"guardrails,omitempty"`
+	Currency          *CurrencyConfig     `yaml:"currency,omitempty"`
+	Daemon            *DaemonConfig       `yaml:"daemon,omitempty"`
+	// File to lock while updating balances, so that two runs never overlap. Defaults to nw-updater.lock in
+	// the user's cache directory.
+	LockFile string         `yaml:"lock_file,omitempty"`
+	Metrics  *MetricsConfig `yaml:"metrics,omitempty"`
+	Mfa      *MfaConfig     `yaml:"mfa,omitempty"`
+	// The number of institutions to get balances from at once, each in its own tab. Defaults to 1.
+	Concurrency int `yaml:"concurrency,omitempty"`
+	// The maximum time to spend getting balances from each institution login, like "5m".
+	InstitutionTimeout time.Duration `yaml:"institution_timeout,omitempty"`
+	// Directory to keep a separate Chrome profile for each institution login, so cookies like trusted device
+	// tokens persist between runs and fewer security codes are needed. Not used with --websocket.
+	ProfilesDir string `yaml:"profiles_dir,omitempty"`
+	// Directory to save the encrypted cookies and localStorage of each institution login after getting balances,
+	// so the next run can skip logging in while the session is still valid.
+	SessionsDir string `yaml:"sessions_dir,omitempty"`
+	// Institutions defined by the steps to log in and find balances, which can be used by name in institutions.
+	ScriptedInstitutions map[string]institution.Script `yaml:"scripted_institutions,omitempty"`
+	// Directory to save the screenshot, HTML and console errors of each institution failure in.
+	FailuresDir string `yaml:"failures_dir,omitempty"`
 }
 
 // InstitutionConfig contains the configs for an account at an institution along with the mapping to a YNAB account.
 type InstitutionConfig struct {
 	Name string           // The name of the institution, for finding the correct instance to get balances
 	Auth institution.Auth // The credentials to log in to the institution
+	// ISO 4217 code of balances that don't show their currency, or only an ambiguous symbol like "$".
+	// Defaults to the budget currency.
+	Currency string `yaml:"currency,omitempty"`
 }
 
 func main() {
@@ -94,6 +191,8 @@ func main() {
 	headlessFlag := flag.Bool("headless", false, "Runs chrome in headless mode (Linux only currently)")
 	websocketFlag := flag.String("websocket", "",
 		"Use existing chrome instance via websocket url (launch chrome with --remote-debugging-port=9222)")
+	dryRunFlag := flag.Bool("dry-run", false, "Print planned adjustments without creating any transactions")
+	forceFlag := flag.Bool("force", false, "Create adjustments even if they violate the configured guardrails")
 	flag.Parse()
 	// read config
 	file, err := os.ReadFile(*configFlag)
@@ -107,40 +206,180 @@ func main() {
 		panic(err)
 	}
 
+	for name, script := range config.ScriptedInstitutions {
+		err = institution.RegisterScripted(name, script)
+		if err != nil {
+			panic(err)
+		}
+	}
+
 	ctx, cancel := GetContext(*headlessFlag, *websocketFlag)
 	defer cancel()
+	var newBrowser BrowserFactory
+	if len(*websocketFlag) == 0 {
+		newBrowser = func(userDataDir string) (context.Context, context.CancelFunc, error) {
+			return NewBrowser(*headlessFlag, userDataDir)
+		}
+	}
 	decryptor := crypto.NewOpenSslDecryptor(*passphraseFileFlag)
 	args := flag.Args()
 	if len(args) > 0 {
 		switch args[0] {
+		case "plan":
+			err = StandardMain(config, ctx, decryptor, RunOptions{DryRun: true, Force: *forceFlag, NewBrowser: newBrowser})
+		case "daemon", "serve":
+			err = DaemonMain(config, ctx, decryptor, RunOptions{Force: *forceFlag, NewBrowser: newBrowser})
 		case "security-code":
-			err = SecurityCodeMain(args[1:], ctx, config.InstitutionConfig, decryptor)
+			err = SecurityCodeMain(args[1:], ctx, config, decryptor, newBrowser)
 		case "simplefin-auth":
 			err = SimpleFinAuthMain(args[1:], *config.SimpleFin)
+		case "history":
+			err = HistoryMain(args[1:], config.History)
+		case "diagnose":
+			err = DiagnoseMain(args[1:], ctx, config, decryptor, newBrowser)
+		case "capture":
+			err = CaptureMain(args[1:], ctx, config, decryptor, newBrowser)
+		case "profiles":
+			err = ProfilesMain(args[1:], config)
 		case "setup":
 			err = SimpleFinSetupMain(config, *configFlag, decryptor)
+		case "validate":
+			err = ValidateMain(config, decryptor)
 		default:
 			panic("unsupported command: " + args[0])
 		}
 	} else {
-		err = StandardMain(config, ctx, decryptor)
+		opts := RunOptions{DryRun: *dryRunFlag, Force: *forceFlag, NewBrowser: newBrowser}
+		if textfile := config.Metrics.textfile(); textfile != "" && !opts.DryRun {
+			opts.Observer = NewMetrics(textfile)
+		}
+		err = LockedRun(config.lockFile(), func() error {
+			return StandardMain(config, ctx, decryptor, opts)
+		})
 	}
 	if err != nil {
-		err = Email(config.EmailConfig, decryptor, err)
-		if err != nil {
-			fmt.Printf("Error sending email with error: %s", err)
-		}
+		ReportError(config, decryptor, err)
+	}
+
+}
+
+// ReportError emails an error from a run.
+func ReportError(config Config, decryptor crypto.OpenSslDecryptor, err error) {
+	err = Email(config.EmailConfig, decryptor, err)
+	if err != nil {
+		fmt.Printf("Error sending email with error: %s", err)
 	}
+}
 
+// RunOptions changes how StandardMain updates balances.
+type RunOptions struct {
+	DryRun    bool            // Print the planned adjustments instead of creating transactions
+	Force     bool            // Ignore the configured guardrails
+	Interrupt context.Context // If non-nil, no more logins or account updates are started once this is done
+	Observer  RunObserver     // If non-nil, notified of the progress of the run
+	// If non-nil and profiles_dir is configured, used to start a browser with its own profile for each login
+	NewBrowser BrowserFactory
 }
 
 // StandardMain is the main function responsible for updating balances, fetching from either YNAB or Actual Budget,
-// and updating either YNAB or Actual Budget.
-func StandardMain(config Config, ctx context.Context, decryptor crypto.OpenSslDecryptor) error {
+// and updating either YNAB or Actual Budget. If opts.DryRun is true, the planned adjustments are printed instead.
+func StandardMain(config Config, ctx context.Context, decryptor crypto.OpenSslDecryptor, opts RunOptions) (err error) {
+	if opts.Interrupt == nil {
+		opts.Interrupt = context.Background()
+	}
+	if opts.Observer == nil {
+		opts.Observer = RunObservers{}
+	}
+	opts.Observer.RunStarted(time.Now())
+	defer func() {
+		opts.Observer.RunFinished(time.Now(), err)
+	}()
+	balances, err := GatherBalances(config, ctx, decryptor, opts)
+	if err != nil {
+		return err
+	}
+	errs := &institution.MultiError{}
+	dests, err := GetDestinations(config, decryptor)
+	if multi, ok := errors.AsType[*institution.MultiError](err); ok && len(dests) > 0 {
+		// update the destinations that were created
+		for _, e := range multi.Errors {
+			fmt.Println(e)
+			errs.AddError(e)
+		}
+	} else if err != nil {
+		return err
+	}
+	if len(dests) == 0 {
+		return fmt.Errorf("error, invalid config file")
+	}
+	balances, err = ConvertBalances(balances, config.Currency)
+	if err != nil {
+		errs.AddError(err)
+	}
+	opts.Observer.BalancesFound(balances)
+	guardrails := config.Guardrails
+	if opts.Force {
+		guardrails = nil
+	}
+	runTime := time.Now()
+	planned := make(map[string][]Adjustment)
+	applied := make(map[string][]Adjustment)
+	for _, d := range dests {
+		if opts.DryRun {
+			adjustments, err := PlanAdjustments(d, balances)
+			if err != nil {
+				errs.AddError(err)
+			}
+			PrintPlan(os.Stdout, d.Name(), adjustments, guardrails)
+		} else {
+			all, adjustments, err := UpdateBalances(opts.Interrupt, d, balances, guardrails)
+			if err != nil {
+				err = fmt.Errorf("error updating %s balances: %w", d.Name(), err)
+				errs.AddError(err)
+			}
+			planned[d.Name()] = all
+			applied[d.Name()] = adjustments
+			for _, adjustment := range adjustments {
+				opts.Observer.AdjustmentCreated(d.Name(), adjustment)
+			}
+			opts.Observer.DestinationUpdated(d.Name(), err)
+		}
+	}
+	if config.History != nil && !opts.DryRun {
+		records := NewHistoryRecords(NewRunId(runTime), runTime, balances, planned, applied)
+		err = HistoryStore{Path: config.History.File}.Append(records)
+		if err != nil {
+			errs.AddError(err)
+		}
+	}
+	if errs.IsEmpty() {
+		return nil
+	}
+	return errs
+}
+
+// GatherBalances gets balances from the configured institutions and SimpleFin, keyed by destination account name.
+// Errors from institutions are emailed rather than returned, so that the balances that were found can still be used.
+func GatherBalances(config Config, ctx context.Context, decryptor crypto.OpenSslDecryptor,
+	opts RunOptions) (map[string]AccountBalance, error) {
+
 	balances := make(map[string]AccountBalance)
 	if len(config.InstitutionConfig) > 0 {
 		var err error
-		balances, err = GetAllBalances(ctx, config.InstitutionConfig, config.AccountMappings, decryptor)
+		// only remote MFA can be used here, since nobody may be at the terminal
+		provider, closeProvider := config.CodeProvider(decryptor, nil)
+		defer closeProvider()
+		balances, err = GetAllBalances(ctx, config.InstitutionConfig, config.AccountMappings, decryptor, ScrapeOptions{
+			Concurrency:  config.Concurrency,
+			Timeout:      config.InstitutionTimeout,
+			Interrupt:    opts.Interrupt,
+			Observer:     opts.Observer,
+			CodeProvider: provider,
+			ProfilesDir:  config.ProfilesDir,
+			NewBrowser:   opts.NewBrowser,
+			SessionsDir:  config.SessionsDir,
+			FailuresDir:  config.FailuresDir,
+		})
 		if err != nil {
 			err = Email(config.EmailConfig, decryptor, err)
 			if err != nil {
@@ -150,29 +389,15 @@ func StandardMain(config Config, ctx context.Context, decryptor crypto.OpenSslDe
 	}
 	if config.SimpleFin != nil {
 		simpleFin := *config.SimpleFin
+		start := time.Now()
 		simpleFinBalances, err := simpleFin.GetBalances(config.AccountMappings)
+		opts.Observer.InstitutionScraped("simplefin", "", time.Since(start), err)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		maps.Copy(balances, simpleFinBalances)
 	}
-	if config.ActualConfig == nil && config.YnabConfig == nil {
-		return fmt.Errorf("error, invalid config file")
-	}
-	if config.ActualConfig != nil {
-		actualBudget := NewActualBudget(*config.ActualConfig, decryptor)
-		err := actualBudget.UpdateBalances(balances)
-		if err != nil {
-			return err
-		}
-	}
-	if config.YnabConfig != nil {
-		err := YnabUpdateBalances(balances, *config.YnabConfig, decryptor)
-		if err != nil {
-			return fmt.Errorf("error updating ynab balances: %w", err)
-		}
-	}
-	return nil
+	return balances, nil
 }
 
 // SimpleFinAuthMain authenticates with SimpleFin and saves the access URL to a file.
@@ -192,8 +417,9 @@ func SimpleFinSetupMain(config Config, configFile string, decryptor crypto.OpenS
 
 // SecurityCodeMain handles the security code entry flow for institutions that require it.
 // It finds the correct institution and account based on the provided args,
-// then prompts the user to enter the code after requesting it from the institution.
-func SecurityCodeMain(args []string, ctx context.Context, configs []InstitutionConfig, decryptor crypto.OpenSslDecryptor) error {
+// then gets the code from the user after requesting it from the institution.
+func SecurityCodeMain(args []string, ctx context.Context, config Config, decryptor crypto.OpenSslDecryptor,
+	newBrowser BrowserFactory) error {
 	// Parse additonal args
 	fs := flag.NewFlagSet("nw-updater security-code", flag.ExitOnError)
 	instString := fs.String("institution", "", "The institution to authenticate with")
@@ -204,23 +430,19 @@ func SecurityCodeMain(args []string, ctx context.Context, configs []InstitutionC
 	if !ok {
 		panic(fmt.Sprintf("%s does not implement SecurityCode", *instString))
 	}
-	var instConfig *InstitutionConfig
-	for _, inst := range configs {
-		if inst.Name == *instString && (*username == "" || inst.Auth.Username == *username) {
-			instConfig = &inst
-			break
-		}
-	}
-	if instConfig == nil {
-		panic("unable to find matching institution in config")
+	instConfig, err := config.FindInstitution(*instString, *username)
+	if err != nil {
+		panic(err)
 	}
-	ctx, cancel, err := sc.RequestCode(ctx, instConfig.Auth, decryptor)
+	// use the login's profile, so a device trusted while entering the code is remembered by later runs
+	ctx, closeBrowser, err := BrowserContext(ctx, config.ProfilesDir, newBrowser, instConfig)
 	if err != nil {
 		return err
 	}
-	defer cancel()
-	code := institution.UserInput("Enter code: ")
-	err = sc.EnterCode(ctx, code)
+	defer closeBrowser()
+	provider, closeProvider := config.CodeProvider(decryptor, institution.TerminalCodeProvider{})
+	defer closeProvider()
+	err = institution.CompleteMfa(ctx, sc, instConfig.Name, instConfig.Auth, decryptor, provider)
 	if err != nil {
 		return err
 	}
@@ -228,26 +450,142 @@ func SecurityCodeMain(args []string, ctx context.Context, configs []InstitutionC
 	return nil
 }
 
+// FindInstitution returns the config for a login at the named institution. username is optional if there is only
+// one login at the institution.
+func (c Config) FindInstitution(name, username string) (InstitutionConfig, error) {
+	for _, inst := range c.InstitutionConfig {
+		if inst.Name == name && (username == "" || inst.Auth.Username == username) {
+			return inst, nil
+		}
+	}
+	return InstitutionConfig{}, errors.New("unable to find matching institution in config")
+}
+
+// CodeProvider returns the MfaBroker if remote MFA is configured, or fallback if not, along with a function
+// to stop the broker.
+func (c Config) CodeProvider(decryptor crypto.OpenSslDecryptor,
+	fallback institution.CodeProvider) (institution.CodeProvider, func()) {
+
+	if c.Mfa == nil {
+		return fallback, func() {}
+	}
+	broker := NewMfaBroker(*c.Mfa, c.EmailConfig, decryptor)
+	return broker, func() {
+		_ = broker.Close()
+	}
+}
+
+// ScrapeOptions controls how GetAllBalances logs in to institutions.
+type ScrapeOptions struct {
+	Concurrency int             // The number of institutions to scrape at once, each in its own tab
+	Timeout     time.Duration   // If non-zero, each login is cancelled if it takes longer than this
+	Interrupt   context.Context // If non-nil, no more logins are started once this is done
+	Observer    RunObserver     // If non-nil, notified after each login
+	// If non-nil, used to complete MFA for SecurityCode institutions that need a code, before trying again
+	CodeProvider institution.CodeProvider
+	// If set along with NewBrowser, each login gets its own browser using a persistent profile in this directory
+	ProfilesDir string
+	NewBrowser  BrowserFactory
+	// If set, each login's session is saved in this directory and restored on the next run
+	SessionsDir string
+	// If set, the details of each failure are saved in a directory for the login in this directory
+	FailuresDir string
+}
+
 // GetAllBalances gets the balances for each InstitutionConfig from the corresponding [institution.Institution]
 // and returns all balances in a map where keys are the YNAB account name and values are in cents.
+// Up to opts.Concurrency institutions are scraped at once. When logins share one browser, logins to the same
+// institution are never run at the same time so they don't clobber each other's cookies, but logins with their
+// own profile in opts.ProfilesDir run in parallel.
 func GetAllBalances(ctx context.Context, config []InstitutionConfig, mappings map[string]string,
-	decryptor crypto.OpenSslDecryptor) (map[string]AccountBalance, error) {
+	decryptor crypto.OpenSslDecryptor, opts ScrapeOptions) (map[string]AccountBalance, error) {
 
+	type result struct {
+		balances map[string]AccountBalance
+		err      error
+	}
+	tabs := make(chan struct{}, max(opts.Concurrency, 1))
+	sharedBrowser := opts.ProfilesDir == "" || opts.NewBrowser == nil
+	locks := make(map[string]*sync.Mutex)
+	for _, ic := range config {
+		locks[ic.Name] = &sync.Mutex{}
+	}
+	results := make(chan result, len(config))
+	for _, ic := range config {
+		go func() {
+			if sharedBrowser {
+				lock := locks[ic.Name]
+				lock.Lock()
+				defer lock.Unlock()
+			}
+			tabs <- struct{}{}
+			defer func() { <-tabs }()
+			if opts.Interrupt != nil && opts.Interrupt.Err() != nil {
+				fmt.Printf("Skipping %s for %s, shutting down\n", ic.Name, ic.Auth.Username)
+				results <- result{}
+				return
+			}
+
+			browserCtx, closeBrowser, err := BrowserContext(ctx, opts.ProfilesDir, opts.NewBrowser, ic)
+			if err != nil {
+				err = fmt.Errorf("failed to get balances from %s: %w", ic.Name, err)
+				fmt.Println(err)
+				results <- result{err: err}
+				return
+			}
+			defer closeBrowser()
+			instCtx, cancel := browserCtx, context.CancelFunc(func() {})
+			if opts.Timeout > 0 {
+				instCtx, cancel = context.WithTimeout(browserCtx, opts.Timeout)
+			}
+			defer cancel()
+			if opts.SessionsDir != "" {
+				instCtx = institution.WithSessionFile(instCtx, SessionFile(opts.SessionsDir, ic.Name, ic.Auth.Username))
+			}
+			if opts.FailuresDir != "" {
+				instCtx = institution.WithFailureDir(instCtx, ProfileDir(opts.FailuresDir, ic.Name, ic.Auth.Username))
+			}
+			fmt.Printf("Getting balances at %s for %s\n", ic.Name, ic.Auth.Username)
+			inst := institution.MustGet(ic.Name)
+			start := time.Now()
+			bs, err := inst.GetBalances(instCtx, ic.Auth, decryptor, FilterMappings(mappings, ic.Name, ic.Auth.Username))
+			if sc, ok := inst.(institution.SecurityCode); ok && opts.CodeProvider != nil &&
+				errors.Is(err, institution.ErrCodeRequired) {
+				fmt.Printf("Security code required at %s for %s\n", ic.Name, ic.Auth.Username)
+				err = institution.CompleteMfa(instCtx, sc, ic.Name, ic.Auth, decryptor, opts.CodeProvider)
+				if err == nil {
+					bs, err = inst.GetBalances(instCtx, ic.Auth, decryptor,
+						FilterMappings(mappings, ic.Name, ic.Auth.Username))
+				}
+			}
+			if opts.Observer != nil {
+				opts.Observer.InstitutionScraped(ic.Name, ic.Auth.Username, time.Since(start), err)
+			}
+			for k, b := range bs {
+				if b.Currency == "" {
+					b.Currency = ic.Currency
+				}
+				b.Source = "institution"
+				b.Institution = ic.Name
+				b.Username = ic.Auth.Username
+				bs[k] = b
+			}
+			fmt.Printf("Found %d matching balances at %s for %s\n", len(bs), ic.Name, ic.Auth.Username)
+			if err != nil {
+				err = fmt.Errorf("failed to get balances from %s: %w", ic.Name, err)
+				fmt.Println(err)
+			}
+			results <- result{balances: bs, err: err}
+		}()
+	}
 	balances := make(map[string]AccountBalance)
 	errs := &institution.MultiError{}
-	for _, ic := range config {
-		fmt.Printf("Getting balances at %s for %s\n", ic.Name, ic.Auth.Username)
-		inst := institution.MustGet(ic.Name)
-		bs, err := inst.GetBalances(ctx, ic.Auth, decryptor, FilterMappings(mappings, ic.Name, ic.Auth.Username))
-		fmt.Printf("Found %d matching balances\n", len(bs))
-		if err != nil {
-			newErr := fmt.Errorf("failed to get balances from %s: %w", ic.Name, err)
-			errs.AddError(newErr)
-			fmt.Println(newErr)
+	for range config {
+		r := <-results
+		if r.err != nil {
+			errs.AddError(r.err)
 		}
-		maps.Copy(balances, bs)
-		// note: there is some strange issue occurring where the next new browser tab context fails to open without a sleep here.
-		time.Sleep(1 * time.Second)
+		maps.Copy(balances, r.balances)
 	}
 	if errs.IsEmpty() {
 		return balances, nil
@@ -267,23 +605,33 @@ func GetContext(headless bool, websocket string) (context.Context, context.Cance
 			cancel1()
 		}
 	}
-	cuConfig := cu.NewConfig()
-	if headless {
-		cuConfig.Headless = true
-	}
-	ctx, cancel, err := cu.New(cuConfig)
+	ctx, cancel, err := NewBrowser(headless, "")
 	if err != nil {
 		panic(err)
 	}
 	return ctx, cancel
 }
 
+// NewBrowser starts a new Chrome instance and returns a [chromedp] context for it. If userDataDir is not empty,
+// it is used as the Chrome profile, so that cookies persist between runs. Otherwise, a temporary profile is used.
+func NewBrowser(headless bool, userDataDir string) (context.Context, context.CancelFunc, error) {
+	cuConfig := cu.NewConfig()
+	if headless {
+		cuConfig.Headless = true
+	}
+	cuConfig.UserDataDir = userDataDir
+	return cu.New(cuConfig)
+}
+
+// FilterMappings returns a copy of mappings where keys prefixed with :institution:username: also appear without
+// the prefix, so that an institution can match account names that are only unique per login.
 func FilterMappings(mappings map[string]string, institution, username string) map[string]string {
 	prefix := ":" + institution + ":" + username + ":"
+	filtered := maps.Clone(mappings)
 	for k, v := range mappings {
 		if strings.Index(k, prefix) == 0 {
-			mappings[k[len(prefix):]] = v
+			filtered[k[len(prefix):]] = v
 		}
 	}
-	return mappings
+	return filtered
 }
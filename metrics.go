@@ -0,0 +1,350 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"math"
+	"net/http"
+	. "nw-updater/common"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsConfig contains where to write Prometheus metrics when not running as a daemon.
+type MetricsConfig struct {
+	// File to write metrics to after each run, for the node_exporter textfile collector. The counters in it are
+	// read back at the start of each run and added to, so they keep increasing when every run is a new process.
+	Textfile string `yaml:"textfile,omitempty"`
+}
+
+// textfile returns the configured textfile, or an empty string if there is no config.
+func (c *MetricsConfig) textfile() string {
+	if c == nil {
+		return ""
+	}
+	return c.Textfile
+}
+
+// scrapeBuckets are the upper bounds in seconds of the scrape duration histogram buckets.
+var scrapeBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600}
+
+type histogram struct {
+	counts []uint64 // cumulative count for each bucket in scrapeBuckets
+	count  uint64
+	sum    float64
+}
+
+// Metrics collects Prometheus metrics as a RunObserver, and writes them in the text exposition format.
+type Metrics struct {
+	mu               sync.Mutex
+	balances         map[[2]string]float64 // account, source; accounts are account_mappings values everywhere
+	adjustments      map[[2]string]uint64  // destination, account
+	adjustmentAmount map[[2]string]float64 // destination, account
+	scrapes          map[[2]string]uint64  // institution, result
+	scrapeDurations  map[string]*histogram // institution
+	destinations     map[[2]string]uint64  // destination, result
+	lastRun          time.Time
+	lastRunSuccess   bool
+	textfile         string
+}
+
+// NewMetrics creates Metrics. If textfile is not empty, the counters and histograms already in it are loaded, and
+// the metrics are written to it after each run.
+func NewMetrics(textfile string) *Metrics {
+	m := &Metrics{
+		balances:         make(map[[2]string]float64),
+		adjustments:      make(map[[2]string]uint64),
+		adjustmentAmount: make(map[[2]string]float64),
+		scrapes:          make(map[[2]string]uint64),
+		scrapeDurations:  make(map[string]*histogram),
+		destinations:     make(map[[2]string]uint64),
+		textfile:         textfile,
+	}
+	if textfile != "" {
+		if err := m.load(textfile); err != nil {
+			fmt.Printf("Error reading metrics, counters will restart from zero: %s\n", err)
+		}
+	}
+	return m
+}
+
+// load adds the counters and histograms in a textfile written by an earlier run. Gauges aren't loaded, since
+// they describe only the run that wrote them.
+func (m *Metrics) load(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for line := range strings.Lines(string(data)) {
+		name, l, value, ok := parseSample(strings.TrimSpace(line))
+		if !ok {
+			continue
+		}
+		switch name {
+		case "nw_updater_adjustments_total":
+			m.adjustments[[2]string{l["destination"], l["account"]}] += uint64(value)
+		case "nw_updater_adjustment_amount_total":
+			m.adjustmentAmount[[2]string{l["destination"], l["account"]}] += value
+		case "nw_updater_scrapes_total":
+			m.scrapes[[2]string{l["institution"], l["result"]}] += uint64(value)
+		case "nw_updater_destination_updates_total":
+			m.destinations[[2]string{l["destination"], l["result"]}] += uint64(value)
+		case "nw_updater_scrape_duration_seconds_bucket":
+			h := m.histogram(l["institution"])
+			for i, bound := range scrapeBuckets {
+				if l["le"] == fmt.Sprint(bound) {
+					h.counts[i] += uint64(value)
+				}
+			}
+		case "nw_updater_scrape_duration_seconds_sum":
+			m.histogram(l["institution"]).sum += value
+		case "nw_updater_scrape_duration_seconds_count":
+			m.histogram(l["institution"]).count += uint64(value)
+		}
+	}
+	return nil
+}
+
+// histogram returns the scrape duration histogram of an institution, creating it if needed.
+func (m *Metrics) histogram(institution string) *histogram {
+	h, ok := m.scrapeDurations[institution]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(scrapeBuckets))}
+		m.scrapeDurations[institution] = h
+	}
+	return h
+}
+
+// resultLabel returns the value of the result label for an error.
+func resultLabel(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+func (m *Metrics) RunStarted(time.Time) {}
+
+func (m *Metrics) InstitutionScraped(institution, _ string, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scrapes[[2]string{institution, resultLabel(err)}]++
+	h := m.histogram(institution)
+	seconds := duration.Seconds()
+	for i, bound := range scrapeBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+func (m *Metrics) BalancesFound(balances map[string]AccountBalance) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for account, balance := range balances {
+		source := balance.Source
+		if balance.Institution != "" {
+			source = balance.Institution
+		}
+		m.balances[[2]string{account, source}] = float64(balance.Balance) / 100
+	}
+}
+
+func (m *Metrics) AdjustmentCreated(destination string, adjustment Adjustment) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := [2]string{destination, adjustment.Mapping}
+	m.adjustments[key]++
+	m.adjustmentAmount[key] += math.Abs(float64(adjustment.Difference())) / 100
+}
+
+func (m *Metrics) DestinationUpdated(destination string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.destinations[[2]string{destination, resultLabel(err)}]++
+}
+
+func (m *Metrics) RunFinished(end time.Time, err error) {
+	m.mu.Lock()
+	m.lastRun = end
+	m.lastRunSuccess = err == nil
+	m.mu.Unlock()
+	if m.textfile != "" {
+		if err := m.WriteTextfile(m.textfile); err != nil {
+			fmt.Printf("Error writing metrics: %s\n", err)
+		}
+	}
+}
+
+// WriteTextfile writes the metrics to a file, replacing it atomically so the collector never reads a partial file.
+func (m *Metrics) WriteTextfile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("error creating metrics file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	err = m.Write(f)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("error writing metrics file: %w", err)
+	}
+	if err = f.Chmod(0644); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing metrics file: %w", err)
+	}
+	if err = f.Close(); err != nil {
+		return fmt.Errorf("error writing metrics file: %w", err)
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// ServeHTTP serves the metrics for Prometheus to scrape.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.Write(w)
+}
+
+// Write writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) Write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b := &strings.Builder{}
+	writeHeader(b, "nw_updater_account_balance", "gauge", "Last balance of each account_mappings value, in the budget currency.")
+	for _, key := range sortedKeys(m.balances) {
+		writeSample(b, "nw_updater_account_balance", labels("account", key[0], "source", key[1]), m.balances[key])
+	}
+	writeHeader(b, "nw_updater_adjustments_total", "counter", "Number of adjustment transactions created.")
+	for _, key := range sortedKeys(m.adjustments) {
+		writeSample(b, "nw_updater_adjustments_total", labels("destination", key[0], "account", key[1]),
+			float64(m.adjustments[key]))
+	}
+	writeHeader(b, "nw_updater_adjustment_amount_total", "counter",
+		"Sum of the absolute amounts of adjustment transactions created.")
+	for _, key := range sortedKeys(m.adjustmentAmount) {
+		writeSample(b, "nw_updater_adjustment_amount_total", labels("destination", key[0], "account", key[1]),
+			m.adjustmentAmount[key])
+	}
+	writeHeader(b, "nw_updater_scrapes_total", "counter", "Number of attempts to get balances from each institution.")
+	for _, key := range sortedKeys(m.scrapes) {
+		writeSample(b, "nw_updater_scrapes_total", labels("institution", key[0], "result", key[1]),
+			float64(m.scrapes[key]))
+	}
+	writeHeader(b, "nw_updater_scrape_duration_seconds", "histogram", "Time taken to get balances from each institution.")
+	for _, institution := range slices.Sorted(maps.Keys(m.scrapeDurations)) {
+		h := m.scrapeDurations[institution]
+		for i, bound := range scrapeBuckets {
+			writeSample(b, "nw_updater_scrape_duration_seconds_bucket",
+				labels("institution", institution, "le", fmt.Sprint(bound)), float64(h.counts[i]))
+		}
+		writeSample(b, "nw_updater_scrape_duration_seconds_bucket", labels("institution", institution, "le", "+Inf"),
+			float64(h.count))
+		writeSample(b, "nw_updater_scrape_duration_seconds_sum", labels("institution", institution), h.sum)
+		writeSample(b, "nw_updater_scrape_duration_seconds_count", labels("institution", institution), float64(h.count))
+	}
+	writeHeader(b, "nw_updater_destination_updates_total", "counter", "Number of attempts to update each destination.")
+	for _, key := range sortedKeys(m.destinations) {
+		writeSample(b, "nw_updater_destination_updates_total", labels("destination", key[0], "result", key[1]),
+			float64(m.destinations[key]))
+	}
+	if !m.lastRun.IsZero() {
+		writeHeader(b, "nw_updater_last_run_timestamp_seconds", "gauge", "Time the last run finished.")
+		writeSample(b, "nw_updater_last_run_timestamp_seconds", "", float64(m.lastRun.Unix()))
+		success := 0.0
+		if m.lastRunSuccess {
+			success = 1
+		}
+		writeHeader(b, "nw_updater_last_run_success", "gauge", "Whether the last run finished without errors.")
+		writeSample(b, "nw_updater_last_run_success", "", success)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func sortedKeys[V any](m map[[2]string]V) [][2]string {
+	return slices.SortedFunc(maps.Keys(m), func(a, b [2]string) int {
+		if c := strings.Compare(a[0], b[0]); c != 0 {
+			return c
+		}
+		return strings.Compare(a[1], b[1])
+	})
+}
+
+func writeHeader(b *strings.Builder, name, metricType, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func writeSample(b *strings.Builder, name, labels string, value float64) {
+	fmt.Fprintf(b, "%s%s %g\n", name, labels, value)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// parseSample parses a line of the text exposition format written by Write, returning false for comments and
+// lines that can't be parsed.
+func parseSample(line string) (name string, labels map[string]string, value float64, ok bool) {
+	i := strings.LastIndexByte(line, ' ')
+	if line == "" || strings.HasPrefix(line, "#") || i == -1 {
+		return "", nil, 0, false
+	}
+	value, err := strconv.ParseFloat(line[i+1:], 64)
+	if err != nil {
+		return "", nil, 0, false
+	}
+	name, rest, hasLabels := strings.Cut(line[:i], "{")
+	labels = make(map[string]string)
+	for hasLabels && rest != "}" {
+		var label string
+		label, rest, ok = strings.Cut(rest, `="`)
+		if !ok {
+			return "", nil, 0, false
+		}
+		v := &strings.Builder{}
+		escaped, closed := false, false
+		for j, r := range rest {
+			switch {
+			case escaped && r == 'n':
+				v.WriteByte('\n')
+			case escaped:
+				v.WriteRune(r)
+			case r == '\\':
+				escaped = true
+				continue
+			case r == '"':
+				rest, closed = rest[j+1:], true
+			default:
+				v.WriteRune(r)
+			}
+			escaped = false
+			if closed {
+				break
+			}
+		}
+		if !closed {
+			return "", nil, 0, false
+		}
+		labels[label] = v.String()
+		rest = strings.TrimPrefix(rest, ",")
+	}
+	return name, labels, value, true
+}
+
+// labels formats pairs of label names and values like {name="value"}.
+func labels(pairs ...string) string {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, pairs[i], labelEscaper.Replace(pairs[i+1])))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	. "nw-updater/common"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordRun records the events of a run with one scrape, balance and adjustment.
+func recordRun(m *Metrics, end time.Time) {
+	m.InstitutionScraped(`fid"el\ity`, "user", 7*time.Second, nil)
+	m.InstitutionScraped("igoe", "user", 2*time.Second, errors.New("failed"))
+	m.BalancesFound(map[string]AccountBalance{"a1": {Balance: 12345, Institution: `fid"el\ity`}})
+	m.AdjustmentCreated("YNAB", Adjustment{Mapping: "a1", AccountName: "Brokerage\nAccount", Current: 12000,
+		New: 12345})
+	m.DestinationUpdated("YNAB", nil)
+	m.RunFinished(end, nil)
+}
+
+func TestMetricsTextfileKeepsCounters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nw_updater.prom")
+	first := NewMetrics(path)
+	recordRun(first, time.Unix(1000, 0))
+	// each run is a new process, like when running from cron
+	second := NewMetrics(path)
+	recordRun(second, time.Unix(2000, 0))
+	b := &strings.Builder{}
+	if err := second.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`nw_updater_account_balance{account="a1",source="fid\"el\\ity"} 123.45`,
+		`nw_updater_adjustments_total{destination="YNAB",account="a1"} 2`,
+		`nw_updater_adjustment_amount_total{destination="YNAB",account="a1"} 6.9`,
+		`nw_updater_scrapes_total{institution="fid\"el\\ity",result="success"} 2`,
+		`nw_updater_scrapes_total{institution="igoe",result="failure"} 2`,
+		`nw_updater_scrape_duration_seconds_bucket{institution="fid\"el\\ity",le="5"} 0`,
+		`nw_updater_scrape_duration_seconds_bucket{institution="fid\"el\\ity",le="10"} 2`,
+		`nw_updater_scrape_duration_seconds_bucket{institution="fid\"el\\ity",le="+Inf"} 2`,
+		`nw_updater_scrape_duration_seconds_sum{institution="fid\"el\\ity"} 14`,
+		`nw_updater_scrape_duration_seconds_count{institution="igoe"} 2`,
+		`nw_updater_destination_updates_total{destination="YNAB",result="success"} 2`,
+		`nw_updater_last_run_timestamp_seconds 2000`,
+	} {
+		if !strings.Contains(b.String(), expected+"\n") {
+			t.Errorf("metrics don't contain %s:\n%s", expected, b)
+		}
+	}
+}
+
+func TestParseSample(t *testing.T) {
+	tests := []struct {
+		line   string
+		name   string
+		labels map[string]string
+		value  float64
+		ok     bool
+	}{
+		{line: `a_total 3`, name: "a_total", labels: map[string]string{}, value: 3, ok: true},
+		{line: `a{x="1",y="two words"} 1.5`, name: "a", labels: map[string]string{"x": "1", "y": "two words"},
+			value: 1.5, ok: true},
+		{line: `a{x="q\"b\\n\n"} 2`, name: "a", labels: map[string]string{"x": "q\"b\\n\n"}, value: 2, ok: true},
+		{line: `# TYPE a counter`},
+		{line: ``},
+		{line: `a{x="1} 2`},
+		{line: `a{x} 2`},
+		{line: `a nope`},
+	}
+	for _, test := range tests {
+		name, labels, value, ok := parseSample(test.line)
+		if ok != test.ok {
+			t.Errorf("parseSample(%q) ok = %t, expected %t", test.line, ok, test.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if name != test.name || value != test.value || len(labels) != len(test.labels) {
+			t.Errorf("parseSample(%q) = %s %v %g", test.line, name, labels, value)
+		}
+		for k, v := range test.labels {
+			if labels[k] != v {
+				t.Errorf("parseSample(%q) label %s = %q, expected %q", test.line, k, labels[k], v)
+			}
+		}
+	}
+}
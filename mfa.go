@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"nw-updater/crypto"
+)
+
+// MfaConfig contains the settings for entering security codes remotely instead of on the terminal.
+type MfaConfig struct {
+	Listen  string        `yaml:"listen"`            // Address to listen on for codes, like ":8081"
+	BaseUrl string        `yaml:"base_url"`          // URL that reaches the listen address, used in links
+	Email   bool          `yaml:"email,omitempty"`   // Send links by email, using the email config
+	Webhook string        `yaml:"webhook,omitempty"` // URL to POST links to as JSON
+	Timeout time.Duration `yaml:"timeout,omitempty"` // How long to wait for a code, defaults to 4m
+}
+
+// MfaBroker is an [institution.CodeProvider] that sends a notification with a one-time link when a security code
+// is needed, then waits for the code to be submitted to a local HTTP endpoint at that link.
+type MfaBroker struct {
+	config      MfaConfig
+	emailConfig EmailConfig
+	decryptor   crypto.OpenSslDecryptor
+	mu          sync.Mutex
+	pending     map[string]pendingCode // keyed by token
+	server      *http.Server
+	client      *http.Client // For webhooks, with a timeout so a hung webhook doesn't hold up the login
+}
+
+type pendingCode struct {
+	institution string
+	username    string
+	code        chan string
+}
+
+// NewMfaBroker creates an MfaBroker. The HTTP endpoint is started the first time a code is needed.
+func NewMfaBroker(config MfaConfig, emailConfig EmailConfig, decryptor crypto.OpenSslDecryptor) *MfaBroker {
+	return &MfaBroker{
+		config:      config,
+		emailConfig: emailConfig,
+		decryptor:   decryptor,
+		pending:     make(map[string]pendingCode),
+		client:      &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// GetCode sends a link for entering the code for a login, and waits for the code to be submitted.
+func (b *MfaBroker) GetCode(ctx context.Context, institution, username string) (string, error) {
+	err := b.start()
+	if err != nil {
+		return "", err
+	}
+	tokenBytes := make([]byte, 16)
+	_, err = rand.Read(tokenBytes)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(tokenBytes)
+	pending := pendingCode{institution: institution, username: username, code: make(chan string, 1)}
+	b.mu.Lock()
+	b.pending[token] = pending
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		delete(b.pending, token)
+		b.mu.Unlock()
+	}()
+
+	link, err := url.JoinPath(b.config.BaseUrl, "mfa", token)
+	if err != nil {
+		return "", err
+	}
+	err = b.notify(institution, username, link)
+	if err != nil {
+		return "", err
+	}
+	fmt.Printf("Waiting for security code for %s (%s) at %s\n", institution, username, link)
+	timeout := b.config.Timeout
+	if timeout == 0 {
+		timeout = 4 * time.Minute
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case code := <-pending.code:
+		return code, nil
+	case <-timer.C:
+		return "", fmt.Errorf("timed out after %s waiting for security code", timeout)
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// notify sends the link by email and/or webhook.
+func (b *MfaBroker) notify(institution, username, link string) error {
+	if !b.config.Email && b.config.Webhook == "" {
+		return errors.New("no email or webhook configured for mfa")
+	}
+	message := fmt.Sprintf("%s needs a security code for %s", institution, username)
+	if b.config.Email {
+		body := fmt.Sprintf("<p>%s.</p><p><a href=\"%s\">Enter the code</a></p>",
+			template.HTMLEscapeString(message), template.HTMLEscapeString(link))
+		err := EmailMessage(b.emailConfig, b.decryptor, "nw-updater security code", body)
+		if err != nil {
+			return fmt.Errorf("error emailing mfa link: %w", err)
+		}
+	}
+	if b.config.Webhook != "" {
+		payload, err := json.Marshal(map[string]string{
+			"institution": institution,
+			"username":    username,
+			"message":     message,
+			"url":         link,
+		})
+		if err != nil {
+			return err
+		}
+		resp, err := b.client.Post(b.config.Webhook, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			return fmt.Errorf("error sending mfa webhook: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("error sending mfa webhook: %s", resp.Status)
+		}
+	}
+	return nil
+}
+
+// start starts the HTTP endpoint if it isn't running.
+func (b *MfaBroker) start() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.server != nil {
+		return nil
+	}
+	if b.config.Listen == "" || b.config.BaseUrl == "" {
+		return errors.New("mfa listen and base_url are required")
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /mfa/{token}", b.form)
+	mux.HandleFunc("POST /mfa/{token}", b.submit)
+	// listen before returning, so a code link is never sent for an endpoint that couldn't start
+	listener, err := net.Listen("tcp", b.config.Listen)
+	if err != nil {
+		return fmt.Errorf("error starting mfa endpoint: %w", err)
+	}
+	server := &http.Server{Addr: b.config.Listen, Handler: mux}
+	b.server = server
+	go func() {
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("Error serving mfa endpoint: %s\n", err)
+		}
+	}()
+	return nil
+}
+
+// Close stops the HTTP endpoint.
+func (b *MfaBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.server == nil {
+		return nil
+	}
+	err := b.server.Shutdown(context.Background())
+	b.server = nil
+	return err
+}
+
+// lookup returns the pending code for a token.
+func (b *MfaBroker) lookup(token string) (pendingCode, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	pending, ok := b.pending[token]
+	return pending, ok
+}
+
+func (b *MfaBroker) form(w http.ResponseWriter, r *http.Request) {
+	pending, ok := b.lookup(r.PathValue("token"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = mfaFormTemplate.Execute(w, pending.institution+" ("+pending.username+")")
+}
+
+// submit accepts the code for a token. Each token can only be used once, but an empty code is rejected without
+// using it up, since it is more likely a stray click than a code.
+func (b *MfaBroker) submit(w http.ResponseWriter, r *http.Request) {
+	token := r.PathValue("token")
+	code := strings.TrimSpace(r.FormValue("code"))
+	if code == "" {
+		if _, ok := b.lookup(token); !ok {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "Enter the security code, go back to try again.", http.StatusBadRequest)
+		return
+	}
+	b.mu.Lock()
+	pending, ok := b.pending[token]
+	delete(b.pending, token)
+	b.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	pending.code <- code
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = fmt.Fprintln(w, "Code submitted.")
+}
+
+var mfaFormTemplate = template.Must(template.New("mfa").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>nw-updater security code</title>
+</head>
+<body style="font-family: sans-serif">
+<p>Enter the security code for {{.}}:</p>
+<form method="post">
+<input name="code" inputmode="numeric" autocomplete="one-time-code" autofocus>
+<button type="submit">Submit</button>
+</form>
+</body>
+</html>
+`))
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"nw-updater/crypto"
+)
+
+// newTestBroker returns an MfaBroker that listens on a free port and posts links to a test webhook.
+func newTestBroker(t *testing.T, webhook http.HandlerFunc) *MfaBroker {
+	server := httptest.NewServer(webhook)
+	t.Cleanup(server.Close)
+	b := NewMfaBroker(MfaConfig{Listen: "127.0.0.1:0", BaseUrl: "http://nw-updater.test", Webhook: server.URL,
+		Timeout: 5 * time.Second}, EmailConfig{}, crypto.OpenSslDecryptor{})
+	t.Cleanup(func() { _ = b.Close() })
+	return b
+}
+
+// pendingToken waits for GetCode to add a pending code, and returns its token.
+func pendingToken(t *testing.T, b *MfaBroker) string {
+	for range 100 {
+		b.mu.Lock()
+		for token := range b.pending {
+			b.mu.Unlock()
+			return token
+		}
+		b.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no pending code")
+	return ""
+}
+
+func postCode(b *MfaBroker, token, code string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/mfa/"+token, strings.NewReader(url.Values{"code": {code}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetPathValue("token", token)
+	w := httptest.NewRecorder()
+	b.submit(w, req)
+	return w
+}
+
+func TestMfaSubmitRejectsEmptyCode(t *testing.T) {
+	b := newTestBroker(t, func(w http.ResponseWriter, r *http.Request) {})
+	result := make(chan string, 1)
+	go func() {
+		code, err := b.GetCode(context.Background(), "fidelity", "user")
+		if err != nil {
+			code = "error: " + err.Error()
+		}
+		result <- code
+	}()
+	token := pendingToken(t, b)
+	for _, code := range []string{"", "  \n"} {
+		if w := postCode(b, token, code); w.Code != http.StatusBadRequest {
+			t.Errorf("empty code %q returned %d, expected 400", code, w.Code)
+		}
+	}
+	if w := postCode(b, "unknown", ""); w.Code != http.StatusNotFound {
+		t.Errorf("empty code for an unknown token returned %d, expected 404", w.Code)
+	}
+	if w := postCode(b, token, " 123456 "); w.Code != http.StatusOK {
+		t.Errorf("code returned %d, expected 200", w.Code)
+	}
+	if code := <-result; code != "123456" {
+		t.Errorf("GetCode returned %q", code)
+	}
+	if w := postCode(b, token, "654321"); w.Code != http.StatusNotFound {
+		t.Errorf("reused token returned %d, expected 404", w.Code)
+	}
+}
+
+func TestMfaWebhookTimeout(t *testing.T) {
+	release := make(chan struct{})
+	b := newTestBroker(t, func(w http.ResponseWriter, r *http.Request) { <-release })
+	defer close(release)
+	b.client.Timeout = 50 * time.Millisecond
+	start := time.Now()
+	_, err := b.GetCode(context.Background(), "fidelity", "user")
+	if err == nil || !strings.Contains(err.Error(), "error sending mfa webhook") {
+		t.Errorf("returned %v, expected a webhook error", err)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("waited %s for a hung webhook", time.Since(start))
+	}
+}
@@ -0,0 +1,170 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A RateProvider gets exchange rates between currencies, identified by ISO 4217 codes like "USD".
+type RateProvider interface {
+	// Rate returns the value of one unit of the from currency in the to currency.
+	Rate(from, to string) (float64, error)
+}
+
+// StaticRates is a RateProvider with a fixed table of rates into a single base currency.
+type StaticRates struct {
+	Base  string
+	Rates map[string]float64 // The value of one unit of each currency in the base currency
+}
+
+func (s StaticRates) Rate(from, to string) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+	if to != s.Base {
+		return 0, fmt.Errorf("no rates into %s, only %s", to, s.Base)
+	}
+	rate, ok := s.Rates[from]
+	if !ok || rate <= 0 {
+		return 0, fmt.Errorf("no rate for %s", from)
+	}
+	return rate, nil
+}
+
+// LoadRatesFile reads StaticRates from a YAML or JSON file containing a map of currency codes to the value of
+// one unit of that currency in base, like {"EUR": 1.08, "GBP": 1.27}.
+func LoadRatesFile(path, base string) (StaticRates, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return StaticRates{}, fmt.Errorf("error reading rates file: %w", err)
+	}
+	rates := make(map[string]float64)
+	err = yaml.Unmarshal(contents, &rates)
+	if err != nil {
+		return StaticRates{}, fmt.Errorf("error parsing rates file: %w", err)
+	}
+	return StaticRates{Base: base, Rates: rates}, nil
+}
+
+// HttpRates is a RateProvider that gets rates from an HTTP endpoint. The url may contain "{base}", which is replaced
+// with the currency being converted into. The endpoint must return JSON like {"rates": {"EUR": 0.92}}, where each
+// rate is the amount of that currency that one unit of the base currency buys, as returned by services like
+// https://api.frankfurter.app/latest?from={base}. Rates are fetched once per base currency.
+type HttpRates struct {
+	Url    string
+	Client *http.Client
+	cache  map[string]map[string]float64
+}
+
+// NewHttpRates creates an HttpRates for the url.
+func NewHttpRates(url string) *HttpRates {
+	return &HttpRates{Url: url, Client: http.DefaultClient, cache: make(map[string]map[string]float64)}
+}
+
+func (h *HttpRates) Rate(from, to string) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+	rates, ok := h.cache[to]
+	if !ok {
+		var err error
+		rates, err = h.fetch(to)
+		if err != nil {
+			return 0, err
+		}
+		h.cache[to] = rates
+	}
+	rate, ok := rates[from]
+	if !ok || rate <= 0 {
+		return 0, fmt.Errorf("no rate for %s", from)
+	}
+	return 1 / rate, nil
+}
+
+// fetch gets the rates for a base currency from the endpoint.
+func (h *HttpRates) fetch(base string) (map[string]float64, error) {
+	resp, err := h.Client.Get(strings.ReplaceAll(h.Url, "{base}", base))
+	if err != nil {
+		return nil, fmt.Errorf("error getting exchange rates: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error getting exchange rates: %s", resp.Status)
+	}
+	var body struct {
+		Rates map[string]float64 `json:"rates"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding exchange rates: %w", err)
+	}
+	if len(body.Rates) == 0 {
+		return nil, errors.New("no exchange rates in response")
+	}
+	return body.Rates, nil
+}
+
+// Convert converts an amount in cents using rate, rounding to the nearest cent.
+func Convert(cents int64, rate float64) int64 {
+	return int64(math.Round(float64(cents) * rate))
+}
+
+// currencyCodes contains the ISO 4217 codes of currencies in use.
+var currencyCodes = map[string]bool{
+	"AED": true, "AFN": true, "ALL": true, "AMD": true, "ANG": true, "AOA": true, "ARS": true, "AUD": true,
+	"AWG": true, "AZN": true, "BAM": true, "BBD": true, "BDT": true, "BGN": true, "BHD": true, "BIF": true,
+	"BMD": true, "BND": true, "BOB": true, "BRL": true, "BSD": true, "BTN": true, "BWP": true, "BYN": true,
+	"BZD": true, "CAD": true, "CDF": true, "CHF": true, "CLP": true, "CNY": true, "COP": true, "CRC": true,
+	"CUP": true, "CVE": true, "CZK": true, "DJF": true, "DKK": true, "DOP": true, "DZD": true, "EGP": true,
+	"ERN": true, "ETB": true, "EUR": true, "FJD": true, "FKP": true, "GBP": true, "GEL": true, "GHS": true,
+	"GIP": true, "GMD": true, "GNF": true, "GTQ": true, "GYD": true, "HKD": true, "HNL": true, "HTG": true,
+	"HUF": true, "IDR": true, "ILS": true, "INR": true, "IQD": true, "IRR": true, "ISK": true, "JMD": true,
+	"JOD": true, "JPY": true, "KES": true, "KGS": true, "KHR": true, "KMF": true, "KPW": true, "KRW": true,
+	"KWD": true, "KYD": true, "KZT": true, "LAK": true, "LBP": true, "LKR": true, "LRD": true, "LSL": true,
+	"LYD": true, "MAD": true, "MDL": true, "MGA": true, "MKD": true, "MMK": true, "MNT": true, "MOP": true,
+	"MRU": true, "MUR": true, "MVR": true, "MWK": true, "MXN": true, "MYR": true, "MZN": true, "NAD": true,
+	"NGN": true, "NIO": true, "NOK": true, "NPR": true, "NZD": true, "OMR": true, "PAB": true, "PEN": true,
+	"PGK": true, "PHP": true, "PKR": true, "PLN": true, "PYG": true, "QAR": true, "RON": true, "RSD": true,
+	"RUB": true, "RWF": true, "SAR": true, "SBD": true, "SCR": true, "SDG": true, "SEK": true, "SGD": true,
+	"SHP": true, "SLE": true, "SOS": true, "SRD": true, "SSP": true, "STN": true, "SYP": true, "SZL": true,
+	"THB": true, "TJS": true, "TMT": true, "TND": true, "TOP": true, "TRY": true, "TTD": true, "TWD": true,
+	"TZS": true, "UAH": true, "UGX": true, "USD": true, "UYU": true, "UZS": true, "VES": true, "VND": true,
+	"VUV": true, "WST": true, "XAF": true, "XCD": true, "XOF": true, "XPF": true, "YER": true, "ZAR": true,
+	"ZMW": true, "ZWG": true,
+}
+
+// currencySymbols maps currency symbols to codes, longest first so that "US$" is found before "$".
+// Symbols used by several currencies, like "$", "¥" and "kr", aren't included.
+var currencySymbols = []struct{ symbol, code string }{
+	{"US$", "USD"}, {"CA$", "CAD"}, {"AU$", "AUD"}, {"NZ$", "NZD"}, {"HK$", "HKD"}, {"MX$", "MXN"},
+	{"C$", "CAD"}, {"A$", "AUD"}, {"S$", "SGD"}, {"R$", "BRL"},
+	{"€", "EUR"}, {"£", "GBP"}, {"₹", "INR"}, {"₩", "KRW"}, {"₪", "ILS"}, {"₱", "PHP"}, {"₺", "TRY"},
+}
+
+// DetectCurrency returns the ISO 4217 code for the currency in a formatted amount, from either a known code like
+// "EUR" or a currency symbol. It returns an empty string if there is no currency or the symbol is used by several
+// currencies, like "$", so the institution's configured currency or the budget's currency can be used instead.
+func DetectCurrency(s string) string {
+	for _, code := range wordPattern.FindAllString(s, -1) {
+		if currencyCodes[code] {
+			return code
+		}
+	}
+	for _, c := range currencySymbols {
+		if strings.Contains(s, c.symbol) {
+			return c.code
+		}
+	}
+	return ""
+}
+
+// wordPattern matches the words in an amount that could be currency codes.
+var wordPattern = regexp.MustCompile(`\b[A-Z]{3}\b`)
@@ -0,0 +1,37 @@
+package money
+
+import "testing"
+
+func TestDetectCurrency(t *testing.T) {
+	tests := []struct{ in, code string }{
+		{"$1,234.56", ""},
+		{"1,234.56", ""},
+		{"¥1,234", ""},
+		{"US$1,234.56", "USD"},
+		{"C$1,234.56", "CAD"},
+		{"CA$1,234.56", "CAD"},
+		{"A$1,234.56", "AUD"},
+		{"1.234,56 €", "EUR"},
+		{"£1,234.56", "GBP"},
+		{"1,234.56 EUR", "EUR"},
+		{"CAD 1,234.56", "CAD"},
+		{"$1,234.56 USD", "USD"},
+		{"ABC 1,234.56", ""},
+		{"1,234.56 DR", ""},
+		{"EURO 1,234.56", ""},
+	}
+	for _, test := range tests {
+		if code := DetectCurrency(test.in); code != test.code {
+			t.Errorf("DetectCurrency(%q) = %q, expected %q", test.in, code, test.code)
+		}
+	}
+}
+
+func TestParseCentsCurrencySymbols(t *testing.T) {
+	for _, in := range []string{"US$1,234.56", "C$1,234.56", "CA$1,234.56", "1,234.56 CAD", "-A$1,234.56"} {
+		cents, err := ParseCents(in)
+		if err != nil || (cents != 123456 && cents != -123456) {
+			t.Errorf("ParseCents(%q) = %d, %v, expected 1234.56", in, cents, err)
+		}
+	}
+}
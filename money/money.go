@@ -0,0 +1,210 @@
+/*
+Package money parses formatted currency amounts like "-$1,234.56", "($1,234.56)" and "1.234,56 €" into cents.
+*/
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Locale contains the separators used when formatting an amount.
+type Locale struct {
+	Decimal   rune
+	Thousands rune
+}
+
+var (
+	US = Locale{Decimal: '.', Thousands: ','} // 1,234.56
+	EU = Locale{Decimal: ',', Thousands: '.'} // 1.234,56
+)
+
+// locales contains the locales that can be chosen by name in the config file.
+var locales = map[string]Locale{"us": US, "eu": EU}
+
+// LocaleByName returns the locale for a name from the config file, "us" or "eu".
+func LocaleByName(name string) (Locale, error) {
+	locale, ok := locales[strings.ToLower(name)]
+	if !ok {
+		return Locale{}, fmt.Errorf("unknown locale '%s', expected us or eu", name)
+	}
+	return locale, nil
+}
+
+var (
+	currencyCodePattern = regexp.MustCompile(`[A-Z]{3}`)
+	creditDebitPattern  = regexp.MustCompile(`(?i)^(CR|DR)\.?|(CR|DR)\.?$`)
+)
+
+// ParseCents parses an amount into cents, detecting whether "." or "," is the decimal separator. An amount
+// with a single separator followed by exactly three digits, like "1,234", is ambiguous and returns an error,
+// so use ParseCentsLocale when the locale is known.
+func ParseCents(s string) (int64, error) {
+	return parse(s, nil)
+}
+
+// ParseCentsLocale parses an amount into cents using the separators from locale.
+func ParseCentsLocale(s string, locale Locale) (int64, error) {
+	return parse(s, &locale)
+}
+
+// parse parses an amount into cents. Currency symbols, ISO currency codes and whitespace are ignored.
+// Negative amounts can have a leading or trailing minus sign, be wrapped in parentheses, or end with "DR".
+// Amounts with no fractional digits are whole units, and more than two fractional digits are rounded.
+func parse(s string, locale *Locale) (int64, error) {
+	negative, number, err := parseSign(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount '%s': %w", s, err)
+	}
+	decimal, thousands, err := separators(number, locale)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount '%s': %w", s, err)
+	}
+	cents, err := parseNumber(number, decimal, thousands)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount '%s': %w", s, err)
+	}
+	if negative {
+		return -cents, nil
+	}
+	return cents, nil
+}
+
+// parseSign removes currency symbols, currency codes and whitespace from an amount, and returns whether
+// it is negative along with the remaining number.
+func parseSign(s string) (bool, string, error) {
+	s = strings.TrimSpace(s)
+	for _, c := range currencySymbols {
+		s = strings.ReplaceAll(s, c.symbol, "")
+	}
+	// codes are removed first, so that one starting or ending with CR or DR like "CRC" isn't read as a sign
+	s = strings.TrimSpace(currencyCodePattern.ReplaceAllString(s, ""))
+	signs := 0
+	negative := false
+	if m := creditDebitPattern.FindStringSubmatch(s); m != nil {
+		signs++
+		negative = strings.EqualFold(m[1]+m[2], "DR")
+		s = creditDebitPattern.ReplaceAllString(s, "")
+	}
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.Is(unicode.Sc, r) || r == '\'' {
+			return -1
+		}
+		return r
+	}, s)
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		signs++
+		negative = true
+		s = s[1 : len(s)-1]
+	}
+	for _, minus := range []string{"-", "−"} {
+		if strings.HasPrefix(s, minus) {
+			signs++
+			negative = true
+			s = s[len(minus):]
+		} else if strings.HasSuffix(s, minus) {
+			signs++
+			negative = true
+			s = s[:len(s)-len(minus)]
+		}
+	}
+	if strings.HasPrefix(s, "+") {
+		signs++
+		s = s[1:]
+	}
+	if signs > 1 {
+		return false, "", errors.New("conflicting signs")
+	}
+	if len(s) == 0 {
+		return false, "", errors.New("no digits")
+	}
+	for _, r := range s {
+		if (r < '0' || r > '9') && r != '.' && r != ',' {
+			return false, "", fmt.Errorf("unexpected character '%c'", r)
+		}
+	}
+	return negative, s, nil
+}
+
+// separators returns the decimal and thousands separators for a number made up of digits, "." and ",".
+// If locale is nil, the separators are detected from the number.
+func separators(number string, locale *Locale) (rune, rune, error) {
+	if locale != nil {
+		return locale.Decimal, locale.Thousands, nil
+	}
+	lastDot := strings.LastIndex(number, ".")
+	lastComma := strings.LastIndex(number, ",")
+	switch {
+	case lastDot != -1 && lastComma != -1:
+		if lastDot > lastComma {
+			return '.', ',', nil
+		}
+		return ',', '.', nil
+	case lastDot == -1 && lastComma == -1:
+		return US.Decimal, US.Thousands, nil
+	}
+	sep, other := '.', ','
+	last := lastDot
+	if lastComma != -1 {
+		sep, other, last = ',', '.', lastComma
+	}
+	if strings.Count(number, string(sep)) > 1 {
+		return other, sep, nil
+	}
+	if len(number)-last-1 == 3 {
+		return 0, 0, fmt.Errorf("ambiguous separator '%c'", sep)
+	}
+	return sep, other, nil
+}
+
+// parseNumber parses a number with the given separators into cents, checking that thousands separators
+// are only used between groups of three digits before the decimal separator.
+func parseNumber(number string, decimal, thousands rune) (int64, error) {
+	whole, fraction, hasDecimal := strings.Cut(number, string(decimal))
+	if hasDecimal && strings.ContainsRune(fraction, decimal) {
+		return 0, fmt.Errorf("more than one decimal separator '%c'", decimal)
+	}
+	if strings.ContainsRune(fraction, thousands) {
+		return 0, fmt.Errorf("thousands separator '%c' after decimal separator", thousands)
+	}
+	if strings.ContainsRune(whole, thousands) {
+		groups := strings.Split(whole, string(thousands))
+		for i, group := range groups {
+			if (i == 0 && (len(group) < 1 || len(group) > 3)) || (i > 0 && len(group) != 3) {
+				return 0, fmt.Errorf("misplaced thousands separator '%c'", thousands)
+			}
+		}
+		whole = strings.Join(groups, "")
+	}
+	if len(whole) == 0 && len(fraction) == 0 {
+		return 0, errors.New("no digits")
+	}
+	var units int64
+	if len(whole) > 0 {
+		var err error
+		units, err = strconv.ParseInt(whole, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		if units > math.MaxInt64/100-1 {
+			return 0, errors.New("amount too large")
+		}
+	}
+	var cents int64
+	for i := range 2 {
+		cents *= 10
+		if i < len(fraction) {
+			cents += int64(fraction[i] - '0')
+		}
+	}
+	// round half away from zero using the first extra digit
+	if len(fraction) > 2 && fraction[2] >= '5' {
+		cents++
+	}
+	return units*100 + cents, nil
+}
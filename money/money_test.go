@@ -0,0 +1,124 @@
+package money
+
+import "testing"
+
+func TestParseCents(t *testing.T) {
+	tests := []struct {
+		in      string
+		cents   int64
+		wantErr bool
+	}{
+		{in: "$1,234.56", cents: 123456},
+		{in: "1234.56", cents: 123456},
+		{in: "$0.00", cents: 0},
+		{in: "12", cents: 1200},
+		{in: "1.5", cents: 150},
+		{in: "1.005", wantErr: true}, // ambiguous, could be 1005
+		{in: "1.2345", cents: 123},
+		{in: "1.23456", cents: 123},
+		{in: "0.125", wantErr: true},
+		{in: "  $ 1,234.56  ", cents: 123456},
+		{in: "USD 1,234.56", cents: 123456},
+
+		// negatives
+		{in: "-$1,234.56", cents: -123456},
+		{in: "$-1,234.56", cents: -123456},
+		{in: "1,234.56-", cents: -123456},
+		{in: "−$5.00", cents: -500},
+		{in: "+$5.00", cents: 500},
+		{in: "($1,234.56)", cents: -123456},
+		{in: "(1,234.56)", cents: -123456},
+		{in: "-($5.00)", wantErr: true},
+		{in: "--5.00", wantErr: true},
+
+		// credit and debit
+		{in: "1,234.56 DR", cents: -123456},
+		{in: "1,234.56 CR", cents: 123456},
+		{in: "DR 1,234.56", cents: -123456},
+		{in: "1,234.56 dr.", cents: -123456},
+		{in: "(5.00) DR", wantErr: true},
+		{in: "CRC 1,234.56", cents: 123456},
+		{in: "1,234.56 CRC", cents: 123456},
+		{in: "CRC 1,234.56 DR", cents: -123456},
+		{in: "USD 1,234.56 CR", cents: 123456},
+
+		// EU separators
+		{in: "1.234,56 €", cents: 123456},
+		{in: "€1.234.567,89", cents: 123456789},
+		{in: "-1.234,56 EUR", cents: -123456},
+		{in: "1234,5", cents: 123450},
+		{in: "1 234,56 €", cents: 123456},
+
+		// ambiguous single separators followed by three digits
+		{in: "1,234", wantErr: true},
+		{in: "1.234", wantErr: true},
+		{in: "1,234,567", cents: 123456700},
+		{in: "1.234.567", cents: 123456700},
+
+		// invalid
+		{in: "", wantErr: true},
+		{in: "$", wantErr: true},
+		{in: "N/A", wantErr: true},
+		{in: "1,23.45", wantErr: true},
+		{in: "1.234,56.78", wantErr: true},
+		{in: "12a.34", wantErr: true},
+	}
+	for _, test := range tests {
+		cents, err := ParseCents(test.in)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseCents(%q) = %d, expected an error", test.in, cents)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseCents(%q) returned error: %s", test.in, err)
+		} else if cents != test.cents {
+			t.Errorf("ParseCents(%q) = %d, expected %d", test.in, cents, test.cents)
+		}
+	}
+}
+
+func TestParseCentsLocale(t *testing.T) {
+	tests := []struct {
+		in      string
+		locale  Locale
+		cents   int64
+		wantErr bool
+	}{
+		{in: "1,234", locale: US, cents: 123400},
+		{in: "1,234", locale: EU, cents: 123},
+		{in: "1.234", locale: US, cents: 123},
+		{in: "1.234", locale: EU, cents: 123400},
+		{in: "$1,234.56", locale: US, cents: 123456},
+		{in: "1.234,56 €", locale: EU, cents: 123456},
+		{in: "(1.234,56)", locale: EU, cents: -123456},
+		{in: "1.234,56 DR", locale: EU, cents: -123456},
+		{in: "1.234,56", locale: US, wantErr: true},
+		{in: "1,234.56", locale: EU, wantErr: true},
+		{in: "12,34.56", locale: US, wantErr: true},
+	}
+	for _, test := range tests {
+		cents, err := ParseCentsLocale(test.in, test.locale)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseCentsLocale(%q, %v) = %d, expected an error", test.in, test.locale, cents)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseCentsLocale(%q, %v) returned error: %s", test.in, test.locale, err)
+		} else if cents != test.cents {
+			t.Errorf("ParseCentsLocale(%q, %v) = %d, expected %d", test.in, test.locale, cents, test.cents)
+		}
+	}
+}
+
+func TestLocaleByName(t *testing.T) {
+	if locale, err := LocaleByName("EU"); err != nil || locale != EU {
+		t.Errorf("LocaleByName(EU) = %v, %v, expected EU", locale, err)
+	}
+	if _, err := LocaleByName("fr"); err == nil {
+		t.Error("LocaleByName(fr) should return an error")
+	}
+}
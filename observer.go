@@ -0,0 +1,60 @@
+package main
+
+import (
+	. "nw-updater/common"
+	"time"
+)
+
+// A RunObserver is notified of what happens during a run, for reporting status and metrics.
+// Methods may be called concurrently.
+type RunObserver interface {
+	RunStarted(start time.Time)
+	// InstitutionScraped is called after getting balances from an institution login.
+	InstitutionScraped(institution, username string, duration time.Duration, err error)
+	// BalancesFound is called with all balances after they are gathered and converted, keyed by account_mappings value.
+	BalancesFound(balances map[string]AccountBalance)
+	// AdjustmentCreated is called after an adjustment transaction is created in a destination.
+	AdjustmentCreated(destination string, adjustment Adjustment)
+	// DestinationUpdated is called after all adjustments are attempted in a destination.
+	DestinationUpdated(destination string, err error)
+	RunFinished(end time.Time, err error)
+}
+
+// RunObservers notifies each RunObserver in turn.
+type RunObservers []RunObserver
+
+func (r RunObservers) RunStarted(start time.Time) {
+	for _, o := range r {
+		o.RunStarted(start)
+	}
+}
+
+func (r RunObservers) InstitutionScraped(institution, username string, duration time.Duration, err error) {
+	for _, o := range r {
+		o.InstitutionScraped(institution, username, duration, err)
+	}
+}
+
+func (r RunObservers) BalancesFound(balances map[string]AccountBalance) {
+	for _, o := range r {
+		o.BalancesFound(balances)
+	}
+}
+
+func (r RunObservers) AdjustmentCreated(destination string, adjustment Adjustment) {
+	for _, o := range r {
+		o.AdjustmentCreated(destination, adjustment)
+	}
+}
+
+func (r RunObservers) DestinationUpdated(destination string, err error) {
+	for _, o := range r {
+		o.DestinationUpdated(destination, err)
+	}
+}
+
+func (r RunObservers) RunFinished(end time.Time, err error) {
+	for _, o := range r {
+		o.RunFinished(end, err)
+	}
+}
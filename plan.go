@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	. "nw-updater/common"
+	"text/tabwriter"
+	"time"
+)
+
+// Adjustment is a planned change to the balance of a destination account, computed before anything is written.
+type Adjustment struct {
+	AccountId   string
+	AccountName string
+	Mapping     string // The account_mappings value the balance was found for, the account's id or name
+	Current     int64  // Current balance in the destination, in cents
+	SubCent     int64  // Rest of the current balance in milliunits, -9 to 9, for a MilliunitDestination
+	New         int64  // New balance from the source, in cents
+	Date        time.Time
+	Source      AccountBalance // The balance the adjustment was planned from
+}
+
+// Difference returns the amount of the adjustment transaction needed to go from the current to the new balance.
+func (a Adjustment) Difference() int64 {
+	return a.New - a.Current
+}
+
+// DifferenceMilliunits returns the exact amount of the adjustment in milliunits, thousandths of a dollar,
+// including the part of the current balance smaller than a cent.
+func (a Adjustment) DifferenceMilliunits() int64 {
+	return a.Difference()*10 - a.SubCent
+}
+
+// Replacing returns the adjustment that replaces an earlier one of earlier milliunits made on the same day, going
+// from the balance before the earlier adjustment to the new balance.
+func (a Adjustment) Replacing(earlier int64) Adjustment {
+	previous := a.Current*10 + a.SubCent - earlier
+	a.Current, a.SubCent = previous/10, previous%10
+	return a
+}
+
+// Unchanged returns true if the current balance already matches the new balance exactly.
+func (a Adjustment) Unchanged() bool {
+	return a.Difference() == 0 && a.SubCent == 0
+}
+
+// PrintPlan writes a table of adjustments for a destination, without making any changes.
+// Adjustments that would be refused by the guardrails are marked as blocked.
+func PrintPlan(w io.Writer, destination string, adjustments []Adjustment, guardrails *GuardrailConfig) {
+	fmt.Fprintf(w, "Planned adjustments in %s:\n", destination)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Account\tCurrent\tNew\tDifference\tDate\tStatus\t")
+	for _, a := range adjustments {
+		status := "update"
+		if a.Unchanged() {
+			status = "unchanged"
+		} else if err := guardrails.Check(a); err != nil {
+			var ge GuardrailError
+			if errors.As(err, &ge) {
+				status = "blocked: " + ge.Reason
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t\n", a.AccountName, formatCents(a.Current), formatCents(a.New),
+			formatCents(a.Difference()), a.Date.Format(time.DateOnly), status)
+	}
+	_ = tw.Flush()
+}
+
+// formatCents formats an amount in cents as dollars, like -$1234.56.
+func formatCents(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s$%d.%02d", sign, cents/100, cents%100)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	. "nw-updater/common"
+	"testing"
+	"time"
+)
+
+// fakeDestination is a Destination with fixed balances in milliunits, recording the adjustments created.
+type fakeDestination struct {
+	accounts   []DestinationAccount
+	milliunits map[string]int64 // Balances by account id
+	created    []Adjustment
+}
+
+func (f *fakeDestination) Name() string {
+	return "Fake"
+}
+
+func (f *fakeDestination) ListAccounts() ([]DestinationAccount, error) {
+	return f.accounts, nil
+}
+
+func (f *fakeDestination) GetBalance(account DestinationAccount, date time.Time) (int64, error) {
+	return f.milliunits[account.Id] / 10, nil
+}
+
+func (f *fakeDestination) CreateAdjustment(adjustment Adjustment) error {
+	f.created = append(f.created, adjustment)
+	return nil
+}
+
+// fakeMilliunitDestination is a fakeDestination that reports the exact milliunits, like YNAB.
+type fakeMilliunitDestination struct {
+	*fakeDestination
+}
+
+func (f fakeMilliunitDestination) GetBalanceMilliunits(account DestinationAccount, date time.Time) (int64, error) {
+	return f.milliunits[account.Id], nil
+}
+
+func TestPlanAdjustmentsMilliunits(t *testing.T) {
+	fake := &fakeDestination{
+		accounts: []DestinationAccount{{Id: "a1", Name: "Brokerage"}, {Id: "a2", Name: "Debt"},
+			{Id: "a3", Name: "Exact"}},
+		milliunits: map[string]int64{"a1": 12345, "a2": -12345, "a3": 12340},
+	}
+	date := time.Now()
+	balances := map[string]AccountBalance{
+		"a1": {Balance: 1234, BalanceDate: date},
+		"a2": {Balance: -1234, BalanceDate: date},
+		"a3": {Balance: 1234, BalanceDate: date},
+	}
+	tests := []struct {
+		d         Destination
+		expected  map[string]int64 // the milliunits of each adjustment
+		unchanged map[string]bool
+	}{
+		// whole cents can't correct a fraction of a cent
+		{d: fake, expected: map[string]int64{"a1": 0, "a2": 0, "a3": 0},
+			unchanged: map[string]bool{"a1": true, "a2": true, "a3": true}},
+		{d: fakeMilliunitDestination{fake}, expected: map[string]int64{"a1": -5, "a2": 5, "a3": 0},
+			unchanged: map[string]bool{"a3": true}},
+	}
+	for _, test := range tests {
+		adjustments, err := PlanAdjustments(test.d, balances)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, a := range adjustments {
+			if got := a.DifferenceMilliunits(); got != test.expected[a.AccountId] {
+				t.Errorf("%T: adjustment of %s is %d milliunits, expected %d", test.d, a.AccountId, got,
+					test.expected[a.AccountId])
+			}
+			if a.Unchanged() != test.unchanged[a.AccountId] {
+				t.Errorf("%T: %s Unchanged() = %t", test.d, a.AccountId, a.Unchanged())
+			}
+		}
+	}
+}
+
+func TestAdjustmentReplacing(t *testing.T) {
+	// an earlier adjustment of +$5.005 took the balance from $100.00 to $105.005, now it should be $103.00
+	a := Adjustment{Current: 10500, SubCent: 5, New: 10300}
+	r := a.Replacing(5005)
+	if r.Current != 10000 || r.SubCent != 0 || r.DifferenceMilliunits() != 3000 {
+		t.Errorf("Replacing(5005) = %+v, expected the change from $100.00 to $103.00", r)
+	}
+	memo, err := r.Memo("{{.Previous}} to {{.New}} ({{.Difference}})")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if memo != "$100.00 to $103.00 ($3.00)" {
+		t.Errorf("memo is %q", memo)
+	}
+	// back to the balance before the earlier adjustment
+	if got := (Adjustment{Current: 10500, SubCent: 5, New: 10000}).Replacing(5005); got.DifferenceMilliunits() != 0 {
+		t.Errorf("replacing an adjustment that cancels out is %d milliunits, expected 0", got.DifferenceMilliunits())
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// ProfileDir returns the Chrome user data directory for an institution login inside profilesDir. The name is the
+// institution and username with unsafe characters replaced, followed by a hash of both so that different logins
+// never share a directory.
+func ProfileDir(profilesDir, institution, username string) string {
+	hash := sha256.Sum256([]byte(institution + "\x00" + username))
+	return filepath.Join(profilesDir, unsafePathChars.ReplaceAllString(institution+"-"+username, "_")+"-"+
+		hex.EncodeToString(hash[:4]))
+}
+
+// SessionFile returns the file to save the session of an institution login in, inside sessionsDir.
+func SessionFile(sessionsDir, institution, username string) string {
+	return ProfileDir(sessionsDir, institution, username) + ".session"
+}
+
+// EnsureProfileDir creates the Chrome user data directory for an institution login if it doesn't exist,
+// and makes sure it and profilesDir can only be accessed by the current user, since they contain session cookies.
+func EnsureProfileDir(profilesDir, institution, username string) (string, error) {
+	dir := ProfileDir(profilesDir, institution, username)
+	// profiles used to be named without the hash, keep using one so its trusted device cookies aren't lost
+	legacy := filepath.Join(profilesDir, unsafePathChars.ReplaceAllString(institution+"-"+username, "_"))
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		if info, err := os.Stat(legacy); err == nil && info.IsDir() {
+			if err = os.Rename(legacy, dir); err != nil {
+				return "", fmt.Errorf("error renaming profile directory: %w", err)
+			}
+		}
+	}
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", fmt.Errorf("error creating profile directory: %w", err)
+	}
+	for _, d := range []string{profilesDir, dir} {
+		err = os.Chmod(d, 0700)
+		if err != nil {
+			return "", fmt.Errorf("error restricting profile directory: %w", err)
+		}
+	}
+	return dir, nil
+}
+
+// ProfilesMain lists or wipes the Chrome profiles of institution logins.
+func ProfilesMain(args []string, config Config) error {
+	if config.ProfilesDir == "" {
+		return errors.New("profiles_dir is not configured")
+	}
+	if len(args) == 0 {
+		return errors.New("expected profiles list or profiles wipe")
+	}
+	switch args[0] {
+	case "list":
+		return listProfiles(config)
+	case "wipe":
+		fs := flag.NewFlagSet("nw-updater profiles wipe", flag.ExitOnError)
+		instString := fs.String("institution", "", "Only wipe profiles for this institution")
+		username := fs.String("username", "", "Only wipe the profile for this username")
+		all := fs.Bool("all", false, "Wipe every profile, including ones no longer in the config")
+		_ = fs.Parse(args[1:])
+		return wipeProfiles(config, *instString, *username, *all)
+	default:
+		return fmt.Errorf("unsupported profiles command: %s", args[0])
+	}
+}
+
+// listProfiles prints each institution login with its profile directory, along with directories that don't
+// belong to any login in the config.
+func listProfiles(config Config) error {
+	entries, err := os.ReadDir(config.ProfilesDir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error reading profiles directory: %w", err)
+	}
+	known := make([]string, 0, len(config.InstitutionConfig))
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Institution\tUsername\tDirectory\tSize\tLast used\t")
+	for _, ic := range config.InstitutionConfig {
+		dir := ProfileDir(config.ProfilesDir, ic.Name, ic.Auth.Username)
+		known = append(known, filepath.Base(dir))
+		size, modified := profileUsage(dir)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t\n", ic.Name, ic.Auth.Username, dir, size, modified)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && !slices.Contains(known, entry.Name()) {
+			dir := filepath.Join(config.ProfilesDir, entry.Name())
+			size, modified := profileUsage(dir)
+			fmt.Fprintf(tw, "(not in config)\t\t%s\t%s\t%s\t\n", dir, size, modified)
+		}
+	}
+	return tw.Flush()
+}
+
+// profileUsage returns the total size and last modified time of a profile directory, formatted for display.
+func profileUsage(dir string) (string, string) {
+	var size int64
+	var modified time.Time
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		size += info.Size()
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+		return nil
+	})
+	if err != nil {
+		return "-", "never"
+	}
+	return fmt.Sprintf("%.1f MB", float64(size)/1e6), modified.Format(time.DateTime)
+}
+
+// wipeProfiles deletes the profile directories of matching institution logins, or every profile if all is true.
+func wipeProfiles(config Config, instName, username string, all bool) error {
+	dirs := make([]string, 0)
+	if all {
+		entries, err := os.ReadDir(config.ProfilesDir)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("error reading profiles directory: %w", err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				dirs = append(dirs, filepath.Join(config.ProfilesDir, entry.Name()))
+			}
+		}
+	} else {
+		if instName == "" && username == "" {
+			return errors.New("specify --institution, --username or --all")
+		}
+		for _, ic := range config.InstitutionConfig {
+			if (instName == "" || ic.Name == instName) && (username == "" || ic.Auth.Username == username) {
+				dirs = append(dirs, ProfileDir(config.ProfilesDir, ic.Name, ic.Auth.Username))
+			}
+		}
+	}
+	for _, dir := range dirs {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			return fmt.Errorf("error wiping profile: %w", err)
+		}
+		fmt.Printf("Wiped %s\n", dir)
+	}
+	return nil
+}
+
+// BrowserFactory starts a new Chrome instance using userDataDir as its profile.
+type BrowserFactory func(userDataDir string) (context.Context, context.CancelFunc, error)
+
+// BrowserContext returns the context to log in to an institution with. If profilesDir and newBrowser are set,
+// it is a new browser using the login's persistent profile, otherwise it is ctx, the shared browser.
+func BrowserContext(ctx context.Context, profilesDir string, newBrowser BrowserFactory,
+	ic InstitutionConfig) (context.Context, context.CancelFunc, error) {
+
+	if profilesDir == "" || newBrowser == nil {
+		return ctx, func() {}, nil
+	}
+	dir, err := EnsureProfileDir(profilesDir, ic.Name, ic.Auth.Username)
+	if err != nil {
+		return nil, nil, err
+	}
+	browserCtx, cancel, err := newBrowser(dir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error starting browser with profile %s: %w", dir, err)
+	}
+	// stop the new browser if the shared context is cancelled, like on shutdown
+	stop := context.AfterFunc(ctx, cancel)
+	return browserCtx, func() {
+		stop()
+		cancel()
+	}, nil
+}
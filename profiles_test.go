@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestProfileDirUnique(t *testing.T) {
+	logins := [][2]string{
+		{"a-b", "c"},
+		{"a", "b-c"},
+		{"bank", "x+1@y"},
+		{"bank", "x_1@y"},
+		{"bank", "x/1@y"},
+	}
+	seen := make(map[string][2]string)
+	for _, login := range logins {
+		dir := ProfileDir("/profiles", login[0], login[1])
+		if filepath.Dir(dir) != "/profiles" {
+			t.Errorf("ProfileDir(%q, %q) = %s is not inside the profiles directory", login[0], login[1], dir)
+		}
+		if other, ok := seen[dir]; ok {
+			t.Errorf("logins %q and %q share %s", login, other, dir)
+		}
+		seen[dir] = login
+	}
+	if dir := ProfileDir("/profiles", "bank", "x+1@y"); !strings.HasPrefix(filepath.Base(dir), "bank-x_1_y-") {
+		t.Errorf("ProfileDir doesn't start with the readable name: %s", dir)
+	}
+}
+
+func TestEnsureProfileDirRenamesLegacy(t *testing.T) {
+	profilesDir := t.TempDir()
+	legacy := filepath.Join(profilesDir, "bank-user")
+	if err := os.MkdirAll(filepath.Join(legacy, "Default"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := EnsureProfileDir(profilesDir, "bank", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir == legacy {
+		t.Fatal("expected the new directory name")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Default")); err != nil {
+		t.Errorf("legacy profile wasn't moved: %s", err)
+	}
+	if _, err := os.Stat(legacy); err == nil {
+		t.Error("legacy profile still exists")
+	}
+}
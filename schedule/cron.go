@@ -0,0 +1,223 @@
+/*
+Package schedule parses cron expressions and calculates when they next run.
+
+Expressions have five fields: minute, hour, day of month, month and day of week, where each field is "*",
+a number, a range like "1-5", a list like "1,15", or any of those with a step like "0-30/10". Month and day of
+week names like "jan" and "mon" are also supported, as are the macros @yearly, @monthly, @weekly, @daily and
+@hourly. As in standard cron, when both day of month and day of week are restricted, either may match.
+*/
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64 // bitsets of the allowed values for each field
+	domStar, dowStar              bool   // whether the day fields were "*", for the day matching rules
+	expr                          string
+}
+
+type field struct {
+	min, max int
+	names    []string // names for values starting at min, if any
+}
+
+var (
+	minuteField = field{min: 0, max: 59}
+	hourField   = field{min: 0, max: 23}
+	domField    = field{min: 1, max: 31}
+	monthField  = field{min: 1, max: 12,
+		names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	dowField = field{min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a cron expression.
+func Parse(expr string) (Schedule, error) {
+	s := Schedule{expr: expr}
+	fields := strings.Fields(expr)
+	if len(fields) == 1 {
+		macro, ok := macros[strings.ToLower(fields[0])]
+		if !ok {
+			return Schedule{}, fmt.Errorf("invalid cron expression '%s': unknown macro", expr)
+		}
+		fields = strings.Fields(macro)
+	}
+	if len(fields) != 5 {
+		return Schedule{}, fmt.Errorf("invalid cron expression '%s': expected 5 fields", expr)
+	}
+	var err error
+	for i, f := range []struct {
+		bits  *uint64
+		field field
+	}{{&s.minute, minuteField}, {&s.hour, hourField}, {&s.dom, domField}, {&s.month, monthField},
+		{&s.dow, dowField}} {
+		*f.bits, err = parseField(fields[i], f.field)
+		if err != nil {
+			return Schedule{}, fmt.Errorf("invalid cron expression '%s': %w", expr, err)
+		}
+	}
+	// 7 is also sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = strings.HasPrefix(fields[2], "*")
+	s.dowStar = strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// MustParse parses a cron expression, panicking if it is invalid.
+func MustParse(expr string) Schedule {
+	s, err := Parse(expr)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// parseField parses one field of a cron expression into a bitset.
+func parseField(s string, f field) (uint64, error) {
+	var bits uint64
+	for part := range strings.SplitSeq(s, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step '%s'", stepPart)
+			}
+		}
+		var low, high int
+		if rangePart == "*" {
+			low, high = f.min, f.max
+		} else {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			low, err = f.value(lowPart)
+			if err != nil {
+				return 0, err
+			}
+			high = low
+			if isRange {
+				high, err = f.value(highPart)
+				if err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				high = f.max
+			}
+			if high < low {
+				return 0, fmt.Errorf("invalid range '%s'", rangePart)
+			}
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// value parses a number or name in a field.
+func (f field) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value '%s'", s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Next returns the first time after t that matches the schedule, in t's location.
+// It returns the zero time if there is no match within the next five years, like for "0 0 30 2 *".
+// Like cron, times skipped when clocks go forward for daylight saving time don't match, and times repeated
+// when clocks go back only match the first time.
+func (s Schedule) Next(t time.Time) time.Time {
+	after := wallClock(t)
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = forward(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()))
+		case !s.dayMatches(t):
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()))
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = forward(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location()))
+		case s.minute&(1<<uint(t.Minute())) == 0 || !wallClock(t).After(after):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// forward returns next if it is after t. Otherwise next was in a gap when clocks went forward, which time.Date
+// may normalize to before t, so the start of the next hour after t is returned instead.
+func forward(t, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+	return t.Add(time.Hour - time.Duration(t.Minute())*time.Minute)
+}
+
+// wallClock returns the date and time shown on a clock in t's location, ignoring the offset, so that times
+// repeated when clocks go back compare as equal.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+// dayMatches checks the day of month and day of week, where either may match if both are restricted.
+func (s Schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func (s Schedule) String() string {
+	return s.expr
+}
+
+// UnmarshalText parses a Schedule from a cron expression, so it can be used directly in config files.
+func (s *Schedule) UnmarshalText(text []byte) error {
+	parsed, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*s = parsed
+	return nil
+}
+
+// MarshalText returns the cron expression of the Schedule.
+func (s Schedule) MarshalText() ([]byte, error) {
+	if s.expr == "" {
+		return nil, errors.New("empty schedule")
+	}
+	return []byte(s.expr), nil
+}
@@ -0,0 +1,191 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@fortnightly",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"* * * foo *",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) should return an error", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// 2026-01-01 is a Thursday
+	start := time.Date(2026, 1, 1, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		from time.Time
+		next []time.Time // successive runs
+	}{
+		{expr: "* * * * *", from: start, next: []time.Time{
+			time.Date(2026, 1, 1, 10, 8, 0, 0, time.UTC),
+			time.Date(2026, 1, 1, 10, 9, 0, 0, time.UTC),
+		}},
+		{expr: "*/15 * * * *", from: start, next: []time.Time{
+			time.Date(2026, 1, 1, 10, 15, 0, 0, time.UTC),
+			time.Date(2026, 1, 1, 10, 30, 0, 0, time.UTC),
+			time.Date(2026, 1, 1, 10, 45, 0, 0, time.UTC),
+			time.Date(2026, 1, 1, 11, 0, 0, 0, time.UTC),
+		}},
+		{expr: "5/20 * * * *", from: start, next: []time.Time{
+			time.Date(2026, 1, 1, 10, 25, 0, 0, time.UTC),
+			time.Date(2026, 1, 1, 10, 45, 0, 0, time.UTC),
+			time.Date(2026, 1, 1, 11, 5, 0, 0, time.UTC),
+		}},
+		{expr: "0 9-17/4 * * *", from: start, next: []time.Time{
+			time.Date(2026, 1, 1, 13, 0, 0, 0, time.UTC),
+			time.Date(2026, 1, 1, 17, 0, 0, 0, time.UTC),
+			time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC),
+		}},
+		{expr: "0,30 8-9 * * *", from: start, next: []time.Time{
+			time.Date(2026, 1, 2, 8, 0, 0, 0, time.UTC),
+			time.Date(2026, 1, 2, 8, 30, 0, 0, time.UTC),
+			time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC),
+			time.Date(2026, 1, 2, 9, 30, 0, 0, time.UTC),
+		}},
+		// weekdays only, by range and by name
+		{expr: "0 6 * * 1-5", from: start, next: []time.Time{
+			time.Date(2026, 1, 2, 6, 0, 0, 0, time.UTC),
+			time.Date(2026, 1, 5, 6, 0, 0, 0, time.UTC),
+		}},
+		{expr: "0 6 * * mon-fri", from: start, next: []time.Time{
+			time.Date(2026, 1, 2, 6, 0, 0, 0, time.UTC),
+			time.Date(2026, 1, 5, 6, 0, 0, 0, time.UTC),
+		}},
+		// 7 is also sunday
+		{expr: "0 0 * * 7", from: start, next: []time.Time{
+			time.Date(2026, 1, 4, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 1, 11, 0, 0, 0, 0, time.UTC),
+		}},
+		// day of month and day of week both restricted: either matches
+		{expr: "0 0 13 * fri", from: start, next: []time.Time{
+			time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 1, 9, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 1, 13, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 1, 16, 0, 0, 0, 0, time.UTC),
+		}},
+		// only one restricted: both must match
+		{expr: "0 0 * * fri", from: start, next: []time.Time{
+			time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 1, 9, 0, 0, 0, 0, time.UTC),
+		}},
+		{expr: "0 0 13 * *", from: start, next: []time.Time{
+			time.Date(2026, 1, 13, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 2, 13, 0, 0, 0, 0, time.UTC),
+		}},
+		{expr: "0 0 */10 * *", from: start, next: []time.Time{
+			time.Date(2026, 1, 11, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 1, 21, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+		}},
+		{expr: "0 0 31 * *", from: start, next: []time.Time{
+			time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC),
+		}},
+		{expr: "0 12 29 feb *", from: start, next: []time.Time{
+			time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC),
+		}},
+		{expr: "@monthly", from: start, next: []time.Time{
+			time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+			time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+		}},
+		{expr: "@weekly", from: start, next: []time.Time{
+			time.Date(2026, 1, 4, 0, 0, 0, 0, time.UTC),
+		}},
+		// never matches
+		{expr: "0 0 30 2 *", from: start, next: []time.Time{{}}},
+	}
+	for _, test := range tests {
+		s, err := Parse(test.expr)
+		if err != nil {
+			t.Errorf("Parse(%q) returned error: %s", test.expr, err)
+			continue
+		}
+		from := test.from
+		for i, expected := range test.next {
+			next := s.Next(from)
+			if !next.Equal(expected) {
+				t.Errorf("%q run %d after %s is %s, expected %s", test.expr, i+1, from, next, expected)
+				break
+			}
+			from = next
+		}
+	}
+}
+
+func TestNextDaylightSaving(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// clocks go forward from 2:00 to 3:00 on 2026-03-08, and back from 2:00 to 1:00 on 2026-11-01
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		next []time.Time
+	}{
+		{name: "skipped time doesn't run", expr: "30 2 * * *", from: time.Date(2026, 3, 7, 12, 0, 0, 0, ny),
+			next: []time.Time{
+				time.Date(2026, 3, 9, 2, 30, 0, 0, ny),
+			}},
+		{name: "hourly across spring forward", expr: "0 * * * *", from: time.Date(2026, 3, 8, 0, 30, 0, 0, ny),
+			next: []time.Time{
+				time.Date(2026, 3, 8, 1, 0, 0, 0, ny),
+				time.Date(2026, 3, 8, 3, 0, 0, 0, ny),
+				time.Date(2026, 3, 8, 4, 0, 0, 0, ny),
+			}},
+		{name: "repeated time runs once", expr: "30 1 * * *", from: time.Date(2026, 10, 31, 12, 0, 0, 0, ny),
+			next: []time.Time{
+				time.Date(2026, 11, 1, 5, 30, 0, 0, time.UTC), // 1:30 EDT
+				time.Date(2026, 11, 2, 1, 30, 0, 0, ny),
+			}},
+		{name: "hourly across fall back", expr: "0 * * * *", from: time.Date(2026, 11, 1, 0, 30, 0, 0, ny),
+			next: []time.Time{
+				time.Date(2026, 11, 1, 5, 0, 0, 0, time.UTC), // 1:00 EDT
+				time.Date(2026, 11, 1, 7, 0, 0, 0, time.UTC), // 2:00 EST
+			}},
+		{name: "daily before and after", expr: "0 9 * * *", from: time.Date(2026, 3, 7, 10, 0, 0, 0, ny),
+			next: []time.Time{
+				time.Date(2026, 3, 8, 9, 0, 0, 0, ny),
+				time.Date(2026, 3, 9, 9, 0, 0, 0, ny),
+			}},
+	}
+	for _, test := range tests {
+		s := MustParse(test.expr)
+		from := test.from
+		for i, expected := range test.next {
+			next := s.Next(from)
+			if !next.Equal(expected) {
+				t.Errorf("%s: %q run %d after %s is %s, expected %s", test.name, test.expr, i+1, from, next,
+					expected)
+				break
+			}
+			if next.Location() != ny {
+				t.Errorf("%s: run %d is in %s, expected %s", test.name, i+1, next.Location(), ny)
+			}
+			from = next
+		}
+	}
+}
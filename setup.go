@@ -11,6 +11,8 @@ import (
 
 	"github.com/yarlson/tap"
 	"gopkg.in/yaml.v3"
+
+	"nw-updater/actual"
 )
 
 const SFMappingPattern = "ACT-[\\da-f]{8}-[\\da-f]{4}-[\\da-f]{4}-[\\da-f]{4}-[\\da-f]{12}"
@@ -43,10 +45,10 @@ func Setup(sf SimpleFin, a ActualBudget, config Config, configFile string) error
 	if err != nil {
 		return err
 	}
-	slices.SortFunc(abAccounts, func(a1, a2 ABAccount) int {
+	slices.SortFunc(abAccounts, func(a1, a2 actual.Account) int {
 		return strings.Compare(a1.Name, a2.Name)
 	})
-	filteredAccounts := make([]ABAccount, 0)
+	filteredAccounts := make([]actual.Account, 0)
 	for _, account := range abAccounts {
 		if account.OffBudget && !account.Closed {
 			filteredAccounts = append(filteredAccounts, account)
@@ -67,8 +69,8 @@ func Setup(sf SimpleFin, a ActualBudget, config Config, configFile string) error
 	for i, sfAccountIndex := range sfAccountIndexes {
 		var selected *int
 		if mapping, ok := mappings[sfAccounts[sfAccountIndex].Id]; ok {
-			selected = new(slices.IndexFunc(filteredAccounts, func(abAccount ABAccount) bool {
-				return abAccount.Name == mapping
+			selected = new(slices.IndexFunc(filteredAccounts, func(abAccount actual.Account) bool {
+				return abAccount.Id == mapping || abAccount.Name == mapping
 			}))
 			if *selected == -1 {
 				selected = nil
@@ -76,7 +78,8 @@ func Setup(sf SimpleFin, a ActualBudget, config Config, configFile string) error
 		}
 		message := fmt.Sprintf("[%d/%d] Select account to sync '%s' to", i, len(sfAccountIndexes), sfNames[sfAccountIndex])
 		abAccountIndex := SingleSelect(message, abNames, selected)
-		updatedMappings[sfAccounts[sfAccountIndex].Id] = filteredAccounts[abAccountIndex].Name
+		// map to the id, so renaming the account in Actual doesn't stop it syncing
+		updatedMappings[sfAccounts[sfAccountIndex].Id] = filteredAccounts[abAccountIndex].Id
 	}
 	f, err := os.OpenFile(configFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
@@ -9,10 +9,9 @@ import (
 	"net/url"
 	. "nw-updater/common"
 	"nw-updater/crypto"
+	"nw-updater/money"
 	"os"
 	"path/filepath"
-	"strconv"
-	"strings"
 	"time"
 )
 
@@ -31,6 +30,7 @@ type SFAccountSet struct {
 // SFAccountJson is the JSON representation of an account in SimpleFin
 type SFAccountJson struct {
 	Name        string `json:"name"`
+	Currency    string `json:"currency"`
 	Balance     string `json:"balance"`
 	BalanceDate int64  `json:"balance-date"`
 	Id          string `json:"id"`
@@ -90,7 +90,7 @@ func (sf SimpleFin) GetAllAccounts() ([]AccountBalance, error) {
 	}
 	accounts := make([]AccountBalance, len(accountSet.Accounts))
 	for i, account := range accountSet.Accounts {
-		balance, err := parseCurrency(account.Balance)
+		balance, err := money.ParseCentsLocale(account.Balance, money.US)
 		if err != nil {
 			return nil, fmt.Errorf("error parsing balance: %w", err)
 		}
@@ -100,6 +100,8 @@ func (sf SimpleFin) GetAllAccounts() ([]AccountBalance, error) {
 			BalanceDate: balanceDate,
 			Id:          account.Id,
 			Name:        account.Name,
+			Source:      "simplefin",
+			Currency:    account.Currency,
 		}
 	}
 	return accounts, nil
@@ -159,10 +161,3 @@ func (sf SimpleFin) GetAccessUrl() (string, error) {
 	}
 	return crypto.DecryptAES256GCM(encrypted, sf.Passphrase)
 }
-
-// parseCurrency removes the decimal point from a string representing a currency amount
-// and converts it to an int64 representing the amount in cents.
-func parseCurrency(s string) (int64, error) {
-	noDots := strings.ReplaceAll(s, ".", "")
-	return strconv.ParseInt(noDots, 10, 64)
-}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"html/template"
+	"maps"
+	"mime"
+	"net/http"
+	. "nw-updater/common"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RunStatus is the status of the last run.
+type RunStatus struct {
+	Running bool      `json:"running"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// AccountStatus is the last balance and adjustment of a destination account.
+type AccountStatus struct {
+	Account          string            `json:"account"`                 // The account_mappings value
+	AccountNames     map[string]string `json:"account_names,omitempty"` // Keyed by destination name
+	Balance          int64             `json:"balance"`
+	BalanceDate      time.Time         `json:"balance_date"`
+	Source           string            `json:"source"`
+	LastAdjustment   map[string]int64  `json:"last_adjustment,omitempty"` // Keyed by destination name
+	LastAdjustmentAt time.Time         `json:"last_adjustment_at"`
+}
+
+// InstitutionStatus is the health of an institution login, or SimpleFin.
+type InstitutionStatus struct {
+	Institution string        `json:"institution"`
+	Username    string        `json:"username,omitempty"`
+	LastAttempt time.Time     `json:"last_attempt"`
+	LastSuccess time.Time     `json:"last_success"`
+	Duration    time.Duration `json:"duration"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// Healthy returns true if the last attempt to get balances succeeded.
+func (i InstitutionStatus) Healthy() bool {
+	return i.Error == ""
+}
+
+// StatusServer keeps track of the status of each run as a RunObserver, and serves it over HTTP as JSON
+// and as an HTML dashboard.
+type StatusServer struct {
+	mu           sync.Mutex
+	run          RunStatus
+	accounts     map[string]*AccountStatus
+	institutions map[string]*InstitutionStatus
+	trigger      chan struct{}
+	password     string
+}
+
+// NewStatusServer creates a StatusServer. If password is not empty, requests must use it with basic auth.
+func NewStatusServer(password string) *StatusServer {
+	return &StatusServer{
+		accounts:     make(map[string]*AccountStatus),
+		institutions: make(map[string]*InstitutionStatus),
+		trigger:      make(chan struct{}, 1),
+		password:     password,
+	}
+}
+
+// Trigger returns a channel that receives when an immediate sync is requested.
+func (s *StatusServer) Trigger() <-chan struct{} {
+	return s.trigger
+}
+
+func (s *StatusServer) RunStarted(start time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.run = RunStatus{Running: true, Start: start}
+}
+
+func (s *StatusServer) InstitutionScraped(institution, username string, duration time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := institution + ":" + username
+	status, ok := s.institutions[key]
+	if !ok {
+		status = &InstitutionStatus{Institution: institution, Username: username}
+		s.institutions[key] = status
+	}
+	status.LastAttempt = time.Now()
+	status.Duration = duration
+	status.Error = ""
+	if err != nil {
+		status.Error = err.Error()
+	} else {
+		status.LastSuccess = status.LastAttempt
+	}
+}
+
+func (s *StatusServer) BalancesFound(balances map[string]AccountBalance) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for account, balance := range balances {
+		status := s.account(account)
+		status.Balance = balance.Balance
+		status.BalanceDate = balance.BalanceDate
+		status.Source = balance.Source
+		if balance.Institution != "" {
+			status.Source = balance.Institution
+		}
+	}
+}
+
+func (s *StatusServer) AdjustmentCreated(destination string, adjustment Adjustment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := s.account(adjustment.Mapping)
+	if status.LastAdjustment == nil {
+		status.LastAdjustment = make(map[string]int64)
+	}
+	if status.AccountNames == nil {
+		status.AccountNames = make(map[string]string)
+	}
+	status.LastAdjustment[destination] = adjustment.Difference()
+	status.AccountNames[destination] = adjustment.AccountName
+	status.LastAdjustmentAt = time.Now()
+}
+
+func (s *StatusServer) DestinationUpdated(string, error) {}
+
+func (s *StatusServer) RunFinished(end time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.run.Running = false
+	s.run.End = end
+	if err != nil {
+		s.run.Error = err.Error()
+	}
+}
+
+// DisplayName returns the names of the account in the destinations it was adjusted in, or its account_mappings
+// value if it hasn't been adjusted, since the value is usually an id.
+func (a AccountStatus) DisplayName() string {
+	names := slices.Compact(slices.Sorted(maps.Values(a.AccountNames)))
+	if len(names) == 0 {
+		return a.Account
+	}
+	return strings.Join(names, ", ")
+}
+
+// account gets or creates the status of an account by its account_mappings value. s.mu must be held.
+func (s *StatusServer) account(mapping string) *AccountStatus {
+	status, ok := s.accounts[mapping]
+	if !ok {
+		status = &AccountStatus{Account: mapping}
+		s.accounts[mapping] = status
+	}
+	return status
+}
+
+// statusSnapshot is a copy of the status that is safe to use without holding the lock.
+type statusSnapshot struct {
+	Run          RunStatus           `json:"run"`
+	Accounts     []AccountStatus     `json:"accounts"`
+	Institutions []InstitutionStatus `json:"institutions"`
+}
+
+func (s *StatusServer) snapshot() statusSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := statusSnapshot{Run: s.run}
+	for _, key := range slices.Sorted(maps.Keys(s.accounts)) {
+		status := *s.accounts[key]
+		status.LastAdjustment = maps.Clone(status.LastAdjustment)
+		status.AccountNames = maps.Clone(status.AccountNames)
+		snapshot.Accounts = append(snapshot.Accounts, status)
+	}
+	for _, key := range slices.Sorted(maps.Keys(s.institutions)) {
+		snapshot.Institutions = append(snapshot.Institutions, *s.institutions[key])
+	}
+	return snapshot
+}
+
+// Handler returns the HTTP handler for the dashboard and API:
+//
+//	GET  /                  HTML dashboard
+//	GET  /api/status        last run status, accounts and institutions
+//	GET  /api/accounts      last balance and adjustment of each account
+//	GET  /api/institutions  login health of each institution
+//	POST /api/sync          start a sync now, with Content-Type: application/json or an X-Requested-With header
+//	GET  /metrics           Prometheus metrics, if metrics is not nil
+//
+// Cross-origin POST requests are rejected, so other websites can't start a sync from the user's browser.
+func (s *StatusServer) Handler(metrics http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	if metrics != nil {
+		mux.Handle("GET /metrics", metrics)
+	}
+	mux.HandleFunc("GET /{$}", s.dashboard)
+	mux.HandleFunc("GET /api/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, s.snapshot())
+	})
+	mux.HandleFunc("GET /api/accounts", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, s.snapshot().Accounts)
+	})
+	mux.HandleFunc("GET /api/institutions", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, s.snapshot().Institutions)
+	})
+	mux.Handle("POST /api/sync", http.NewCrossOriginProtection().Handler(http.HandlerFunc(s.sync)))
+	return s.authenticate(mux)
+}
+
+// authenticate requires basic auth with the password, if there is one.
+func (s *StatusServer) authenticate(next http.Handler) http.Handler {
+	if s.password == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, password, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="nw-updater"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sync requests an immediate sync. If one is already requested, this does nothing.
+// Browsers can only send a JSON content type or a custom header from other sites after a CORS preflight, which
+// isn't allowed, so requiring one stops forms on other sites from starting a sync.
+func (s *StatusServer) sync(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" && r.Header.Get("X-Requested-With") == "" {
+		http.Error(w, "Content-Type must be application/json, or set X-Requested-With", http.StatusUnsupportedMediaType)
+		return
+	}
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	writeJson(w, map[string]string{"status": "sync requested"})
+}
+
+func writeJson(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (s *StatusServer) dashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := dashboardTemplate.Execute(w, s.snapshot())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"cents": formatCents,
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return t.Local().Format("Jan 2 15:04")
+	},
+	"date": func(t time.Time) string {
+		return t.Format(time.DateOnly)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="60">
+<title>nw-updater</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 1.5em; }
+th, td { text-align: left; padding: 0.3em 0.5em; border-bottom: 1px solid #ddd; }
+.ok { color: #080; }
+.error { color: #b00; }
+</style>
+</head>
+<body>
+<h1>nw-updater</h1>
+<h2>Last run</h2>
+{{with .Run}}
+<p>
+{{if .Running}}Running since {{time .Start}}{{else}}Finished {{time .End}}{{end}}
+{{if .Error}}<br><span class="error">{{.Error}}</span>{{else if not .End.IsZero}}<span class="ok">OK</span>{{end}}
+</p>
+{{end}}
+<button onclick="fetch('/api/sync', {method: 'POST', headers: {'Content-Type': 'application/json'}}).then(() => location.reload())">Sync now</button>
+<h2>Institutions</h2>
+<table>
+<tr><th>Institution</th><th>Last attempt</th><th>Last success</th><th>Status</th></tr>
+{{range .Institutions}}
+<tr><td>{{.Institution}} {{.Username}}</td><td>{{time .LastAttempt}}</td><td>{{time .LastSuccess}}</td>
+<td>{{if .Healthy}}<span class="ok">OK</span>{{else}}<span class="error">{{.Error}}</span>{{end}}</td></tr>
+{{end}}
+</table>
+<h2>Accounts</h2>
+<table>
+<tr><th>Account</th><th>Balance</th><th>As of</th><th>Last adjustment</th></tr>
+{{range .Accounts}}
+<tr><td>{{.DisplayName}}</td><td>{{cents .Balance}}</td><td>{{date .BalanceDate}}</td>
+<td>{{range $d, $a := .LastAdjustment}}{{$d}}: {{cents $a}}<br>{{end}}</td></tr>
+{{end}}
+</table>
+</body>
+</html>
+`))
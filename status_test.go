@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	. "nw-updater/common"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusSync(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string // the password sent with basic auth, if not empty
+		header   map[string]string
+		status   int
+	}{
+		{name: "no password", header: map[string]string{"Content-Type": "application/json"},
+			status: http.StatusUnauthorized},
+		{name: "wrong password", password: "wrong", header: map[string]string{"Content-Type": "application/json"},
+			status: http.StatusUnauthorized},
+		{name: "json", password: "secret", header: map[string]string{"Content-Type": "application/json"},
+			status: http.StatusAccepted},
+		{name: "json with charset", password: "secret",
+			header: map[string]string{"Content-Type": "application/json; charset=utf-8"}, status: http.StatusAccepted},
+		{name: "x-requested-with", password: "secret", header: map[string]string{"X-Requested-With": "fetch"},
+			status: http.StatusAccepted},
+		{name: "form", password: "secret",
+			header: map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+			status: http.StatusUnsupportedMediaType},
+		{name: "no content type", password: "secret", status: http.StatusUnsupportedMediaType},
+		{name: "cross site", password: "secret", header: map[string]string{"Content-Type": "application/json",
+			"Sec-Fetch-Site": "cross-site"}, status: http.StatusForbidden},
+		{name: "cross origin", password: "secret", header: map[string]string{"Content-Type": "application/json",
+			"Origin": "https://example.com"}, status: http.StatusForbidden},
+		{name: "same origin", password: "secret", header: map[string]string{"Content-Type": "application/json",
+			"Sec-Fetch-Site": "same-origin"}, status: http.StatusAccepted},
+	}
+	for _, test := range tests {
+		s := NewStatusServer("secret")
+		r := httptest.NewRequest(http.MethodPost, "http://127.0.0.1:8080/api/sync", strings.NewReader("{}"))
+		if test.password != "" {
+			r.SetBasicAuth("user", test.password)
+		}
+		for k, v := range test.header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		s.Handler(nil).ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s: status %d, expected %d: %s", test.name, w.Code, test.status, w.Body)
+		}
+		triggered := len(s.Trigger()) == 1
+		if triggered != (test.status == http.StatusAccepted) {
+			t.Errorf("%s: sync triggered = %t", test.name, triggered)
+		}
+	}
+}
+
+func TestStatusWithoutPassword(t *testing.T) {
+	s := NewStatusServer("")
+	for range 2 {
+		r := httptest.NewRequest(http.MethodPost, "/api/sync", nil)
+		r.Header.Set("X-Requested-With", "fetch")
+		w := httptest.NewRecorder()
+		s.Handler(nil).ServeHTTP(w, r)
+		if w.Code != http.StatusAccepted {
+			t.Errorf("status %d, expected %d", w.Code, http.StatusAccepted)
+		}
+	}
+	// requests while a sync is waiting to start don't queue another
+	if len(s.Trigger()) != 1 {
+		t.Errorf("%d syncs triggered, expected 1", len(s.Trigger()))
+	}
+}
+
+func TestStatusAccounts(t *testing.T) {
+	s := NewStatusServer("")
+	s.BalancesFound(map[string]AccountBalance{
+		"acct-1": {Balance: 12345, Institution: "fidelity"},
+		"acct-2": {Balance: 500, Source: "simplefin"},
+	})
+	s.AdjustmentCreated("YNAB", Adjustment{Mapping: "acct-1", AccountId: "acct-1", AccountName: "Brokerage",
+		Current: 12000, New: 12345, Date: time.Now()})
+	w := httptest.NewRecorder()
+	s.Handler(nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/accounts", nil))
+	var accounts []AccountStatus
+	if err := json.NewDecoder(w.Body).Decode(&accounts); err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 2 {
+		t.Fatalf("got %d accounts, expected the balance and adjustment merged into 2: %+v", len(accounts), accounts)
+	}
+	if a := accounts[0]; a.Account != "acct-1" || a.Source != "fidelity" || a.LastAdjustment["YNAB"] != 345 ||
+		a.DisplayName() != "Brokerage" {
+		t.Errorf("got account %+v", a)
+	}
+	if a := accounts[1]; a.Account != "acct-2" || a.DisplayName() != "acct-2" {
+		t.Errorf("got account %+v", a)
+	}
+	w = httptest.NewRecorder()
+	s.Handler(nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !strings.Contains(w.Body.String(), "<tr><td>Brokerage</td><td>$123.45</td>") {
+		t.Errorf("dashboard doesn't show the account name:\n%s", w.Body)
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Package totp generates time-based one-time passwords as described in RFC 6238, like authenticator apps do.
+*/
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Key is a TOTP secret along with the parameters for generating codes.
+type Key struct {
+	Secret    []byte
+	Digits    int              // Number of digits in each code, usually 6
+	Period    time.Duration    // How long each code is valid, usually 30 seconds
+	Algorithm func() hash.Hash // HMAC hash function, usually SHA-1
+}
+
+// ParseKey parses a base32 secret, as shown by sites when setting up an authenticator app, or an otpauth:// URI
+// from the setup QR code. Spaces, dashes and padding in the secret are ignored, and letters may be any case.
+func ParseKey(s string) (Key, error) {
+	key := Key{Digits: 6, Period: 30 * time.Second, Algorithm: sha1.New}
+	secret := strings.TrimSpace(s)
+	if strings.HasPrefix(secret, "otpauth://") {
+		u, err := url.Parse(secret)
+		if err != nil {
+			return Key{}, fmt.Errorf("invalid otpauth uri: %w", err)
+		}
+		q := u.Query()
+		secret = q.Get("secret")
+		if digits := q.Get("digits"); digits != "" {
+			key.Digits, err = strconv.Atoi(digits)
+			if err != nil || key.Digits < 6 || key.Digits > 10 {
+				return Key{}, fmt.Errorf("invalid otpauth digits '%s'", digits)
+			}
+		}
+		if period := q.Get("period"); period != "" {
+			seconds, err := strconv.Atoi(period)
+			if err != nil || seconds <= 0 {
+				return Key{}, fmt.Errorf("invalid otpauth period '%s'", period)
+			}
+			key.Period = time.Duration(seconds) * time.Second
+		}
+		switch strings.ToUpper(q.Get("algorithm")) {
+		case "", "SHA1":
+		case "SHA256":
+			key.Algorithm = sha256.New
+		case "SHA512":
+			key.Algorithm = sha512.New
+		default:
+			return Key{}, fmt.Errorf("unsupported otpauth algorithm '%s'", q.Get("algorithm"))
+		}
+	}
+	secret = strings.ToUpper(strings.NewReplacer(" ", "", "-", "", "=", "").Replace(secret))
+	if secret == "" {
+		return Key{}, errors.New("empty totp secret")
+	}
+	decoded, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return Key{}, fmt.Errorf("invalid totp secret: %w", err)
+	}
+	key.Secret = decoded
+	return key, nil
+}
+
+// Code returns the code for the time t.
+func (k Key) Code(t time.Time) string {
+	return hotp(k.Secret, uint64(t.Unix()/int64(k.Period.Seconds())), k.Digits, k.Algorithm)
+}
+
+// Remaining returns how long the code for time t is still valid.
+func (k Key) Remaining(t time.Time) time.Duration {
+	period := int64(k.Period.Seconds())
+	return time.Duration(period-t.Unix()%period) * time.Second
+}
+
+// Generate parses a secret with ParseKey and returns the code for the time t.
+func Generate(secret string, t time.Time) (string, error) {
+	key, err := ParseKey(secret)
+	if err != nil {
+		return "", err
+	}
+	return key.Code(t), nil
+}
+
+// hotp generates an HMAC-based one-time password as described in RFC 4226.
+func hotp(secret []byte, counter uint64, digits int, algorithm func() hash.Hash) string {
+	mac := hmac.New(algorithm, secret)
+	_ = binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulus := uint64(1)
+	for range digits {
+		modulus *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, uint64(value)%modulus)
+}
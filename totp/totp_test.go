@@ -0,0 +1,96 @@
+package totp
+
+import (
+	"encoding/base32"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// The test vectors from RFC 6238 appendix B, with the seed for each algorithm.
+func TestCodeRfc6238(t *testing.T) {
+	seeds := map[string]string{
+		"SHA1":   "12345678901234567890",
+		"SHA256": "12345678901234567890123456789012",
+		"SHA512": "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	tests := []struct {
+		time  int64
+		codes map[string]string // by algorithm
+	}{
+		{time: 59, codes: map[string]string{"SHA1": "94287082", "SHA256": "46119246", "SHA512": "90693936"}},
+		{time: 1111111109, codes: map[string]string{"SHA1": "07081804", "SHA256": "68084774", "SHA512": "25091201"}},
+		{time: 1111111111, codes: map[string]string{"SHA1": "14050471", "SHA256": "67062674", "SHA512": "99943326"}},
+		{time: 1234567890, codes: map[string]string{"SHA1": "89005924", "SHA256": "91819424", "SHA512": "93441116"}},
+		{time: 2000000000, codes: map[string]string{"SHA1": "69279037", "SHA256": "90698825", "SHA512": "38618901"}},
+		{time: 20000000000, codes: map[string]string{"SHA1": "65353130", "SHA256": "77737706", "SHA512": "47863826"}},
+	}
+	for algorithm, seed := range seeds {
+		uri := fmt.Sprintf("otpauth://totp/Example:user?secret=%s&digits=8&period=30&algorithm=%s",
+			base32.StdEncoding.EncodeToString([]byte(seed)), algorithm)
+		key, err := ParseKey(uri)
+		if err != nil {
+			t.Fatalf("ParseKey(%q) returned %s", uri, err)
+		}
+		for _, test := range tests {
+			if code := key.Code(time.Unix(test.time, 0)); code != test.codes[algorithm] {
+				t.Errorf("%s code at %d = %s, expected %s", algorithm, test.time, code, test.codes[algorithm])
+			}
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	// the SHA1 seed from RFC 6238, whose 6 digit code at 59 seconds is 287082
+	tests := []struct {
+		in      string
+		code    string
+		wantErr bool
+	}{
+		{in: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", code: "287082"},
+		{in: "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code: "287082"},
+		{in: " GEZD GNBV GY3T QOJQ GEZD GNBV GY3T QOJQ\n", code: "287082"},
+		{in: "GEZD-GNBV-GY3T-QOJQ-GEZD-GNBV-GY3T-QOJQ", code: "287082"},
+		{in: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ====", code: "287082"},
+		{in: "otpauth://totp/Example:user?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&issuer=Example", code: "287082"},
+		{in: "otpauth://totp/Example:user?secret=gezdgnbvgy3tqojqgezdgnbvgy3tqojq&digits=8&algorithm=sha1",
+			code: "94287082"},
+		// counter 0 with a 60 second period, which is the same as the RFC's counter 1 code at 30 seconds
+		{in: "otpauth://totp/Example:user?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&period=60", code: "755224"},
+		{in: "otpauth://totp/Example:user?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&digits=5", wantErr: true},
+		{in: "otpauth://totp/Example:user?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&digits=11", wantErr: true},
+		{in: "otpauth://totp/Example:user?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&digits=six", wantErr: true},
+		{in: "otpauth://totp/Example:user?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&period=0", wantErr: true},
+		{in: "otpauth://totp/Example:user?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&period=-30", wantErr: true},
+		{in: "otpauth://totp/Example:user?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&algorithm=MD5", wantErr: true},
+		{in: "otpauth://totp/Example:user?issuer=Example", wantErr: true},
+		{in: "", wantErr: true},
+		{in: "GEZDGNBVGY3TQOJ1", wantErr: true},
+	}
+	for _, test := range tests {
+		key, err := ParseKey(test.in)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("ParseKey(%q) should return an error", test.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseKey(%q) returned %s", test.in, err)
+			continue
+		}
+		if code := key.Code(time.Unix(59, 0)); code != test.code {
+			t.Errorf("ParseKey(%q) code at 59 = %s, expected %s", test.in, code, test.code)
+		}
+	}
+}
+
+func TestRemaining(t *testing.T) {
+	key := Key{Period: 30 * time.Second}
+	for seconds, expected := range map[int64]time.Duration{0: 30 * time.Second, 29: time.Second, 59: time.Second,
+		61: 29 * time.Second} {
+		if remaining := key.Remaining(time.Unix(seconds, 0)); remaining != expected {
+			t.Errorf("Remaining at %d = %s, expected %s", seconds, remaining, expected)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// defaultMemo is the memo template used when none is configured.
+const defaultMemo = `Entered automatically by nw-updater{{if .OriginalCurrency}} (converted from {{.OriginalBalance}} ` +
+	`{{.OriginalCurrency}} at {{printf "%g" .ExchangeRate}}){{end}}`
+
+// TransactionOptions contains the fields of the adjustment transactions created in a destination.
+// Unset fields are inherited from the destination's defaults.
+type TransactionOptions struct {
+	Payee *string `yaml:"payee,omitempty"` // Payee name, defaults to "Market Changes"
+	// Category id or name, only for on-budget Actual accounts since tracking accounts can't have categories
+	Category *string `yaml:"category,omitempty"`
+	Cleared  *bool   `yaml:"cleared,omitempty"` // Whether the transaction is cleared, defaults to true, Actual only
+	Memo     *string `yaml:"memo,omitempty"`    // text/template for the memo, executed with a MemoData
+}
+
+// TransactionConfig contains the default TransactionOptions for a destination, and overrides for individual
+// destination accounts, by account_mappings value or account name.
+type TransactionConfig struct {
+	TransactionOptions `yaml:",inline"`
+	Accounts           map[string]TransactionOptions `yaml:"accounts,omitempty"`
+	// Update the adjustment already created on the same account and date instead of adding another,
+	// deleting it if the changes cancel out, so running several times a day leaves one transaction
+	Idempotent bool `yaml:"idempotent,omitempty"`
+}
+
+// adjustmentMarker is added to the notes of adjustments in idempotent mode, to find them again.
+// Actual shows it as a tag.
+const adjustmentMarker = "#nw-updater"
+
+// IsIdempotent returns true if existing adjustments should be updated instead of adding new ones.
+func (c *TransactionConfig) IsIdempotent() bool {
+	return c != nil && c.Idempotent
+}
+
+// AdjustmentTransaction contains the resolved fields of an adjustment transaction.
+type AdjustmentTransaction struct {
+	Payee    string
+	Category string
+	Cleared  bool
+	Memo     string
+}
+
+// MemoData contains the fields available to memo templates. Amounts are formatted like $1234.56.
+type MemoData struct {
+	Source        string  // "institution" or "simplefin"
+	Institution   string  // The institution name, for balances from an institution login
+	Username      string  // The username of the institution login
+	SourceAccount string  // The account name at the source
+	Account       string  // The account name in the destination
+	Previous      string  // The balance in the destination before the adjustment
+	New           string  // The new balance
+	Difference    string  // The amount of the adjustment
+	PercentChange float64 // The difference as a percent of the previous balance, or 0 if it was zero
+	BalanceDate   string  // The date of the new balance, YYYY-MM-DD
+
+	// Set when the balance was converted from another currency
+	OriginalBalance  string // A plain decimal number, like 1234.56
+	OriginalCurrency string
+	ExchangeRate     float64
+}
+
+// Validate checks that every memo template can be parsed, so mistakes are found before any balances are fetched.
+func (c *TransactionConfig) Validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.Memo != nil {
+		if _, err := parseMemo(*c.Memo); err != nil {
+			return err
+		}
+	}
+	for account, options := range c.Accounts {
+		if options.Memo != nil {
+			if _, err := parseMemo(*options.Memo); err != nil {
+				return fmt.Errorf("account '%s': %w", account, err)
+			}
+		}
+	}
+	return nil
+}
+
+// For returns the options for an adjustment, with any unset fields taken from the destination's defaults and
+// then the built-in defaults. A nil TransactionConfig uses the built-in defaults.
+func (c *TransactionConfig) For(a Adjustment) TransactionOptions {
+	result := TransactionOptions{
+		Payee:   new("Market Changes"),
+		Cleared: new(true),
+		Memo:    new(defaultMemo),
+	}
+	if c == nil {
+		return result
+	}
+	overrides := []TransactionOptions{c.TransactionOptions}
+	// overrides can use the mapped id or the account name
+	if override, ok := c.Accounts[a.Mapping]; ok {
+		overrides = append(overrides, override)
+	} else if override, ok := c.Accounts[a.AccountName]; ok {
+		overrides = append(overrides, override)
+	}
+	for _, override := range overrides {
+		if override.Payee != nil {
+			result.Payee = override.Payee
+		}
+		if override.Category != nil {
+			result.Category = override.Category
+		}
+		if override.Cleared != nil {
+			result.Cleared = override.Cleared
+		}
+		if override.Memo != nil {
+			result.Memo = override.Memo
+		}
+	}
+	return result
+}
+
+// Transaction returns the fields of the transaction for an adjustment, executing its memo template.
+// The category is returned as configured, destinations look it up by id or name.
+func (c *TransactionConfig) Transaction(a Adjustment) (AdjustmentTransaction, error) {
+	options := c.For(a)
+	memo, err := a.Memo(*options.Memo)
+	if err != nil {
+		return AdjustmentTransaction{}, err
+	}
+	result := AdjustmentTransaction{Payee: *options.Payee, Cleared: *options.Cleared, Memo: memo}
+	if options.Category != nil {
+		result.Category = *options.Category
+	}
+	return result, nil
+}
+
+// Memo executes a memo template for the adjustment.
+func (a Adjustment) Memo(memoTemplate string) (string, error) {
+	tmpl, err := parseMemo(memoTemplate)
+	if err != nil {
+		return "", err
+	}
+	data := MemoData{
+		Source:           a.Source.Source,
+		Institution:      a.Source.Institution,
+		Username:         a.Source.Username,
+		SourceAccount:    a.Source.Name,
+		Account:          a.AccountName,
+		Previous:         formatCents(a.Current),
+		New:              formatCents(a.New),
+		Difference:       formatCents(a.Difference()),
+		OriginalCurrency: a.Source.OriginalCurrency,
+		ExchangeRate:     a.Source.ExchangeRate,
+	}
+	if a.Current != 0 {
+		data.PercentChange = float64(a.Difference()) / math.Abs(float64(a.Current)) * 100
+	}
+	if !a.Date.IsZero() {
+		data.BalanceDate = a.Date.Format(time.DateOnly)
+	}
+	if a.Source.OriginalCurrency != "" {
+		data.OriginalBalance = centsToDecimal(a.Source.OriginalBalance)
+	}
+	memo := &strings.Builder{}
+	err = tmpl.Execute(memo, data)
+	if err != nil {
+		return "", fmt.Errorf("error executing memo template: %w", err)
+	}
+	return memo.String(), nil
+}
+
+// category is a budget category that adjustments can be assigned to.
+type category struct {
+	id   string
+	name string
+}
+
+// findCategory returns the id of the category whose id is key, or else the only category named key.
+func findCategory(categories []category, key string) (string, error) {
+	var ids []string
+	for _, c := range categories {
+		if c.id == key {
+			return c.id, nil
+		}
+		if c.name == key {
+			ids = append(ids, c.id)
+		}
+	}
+	switch len(ids) {
+	case 0:
+		return "", fmt.Errorf("no category with id or name '%s'", key)
+	case 1:
+		return ids[0], nil
+	default:
+		return "", fmt.Errorf("%d categories are named '%s', use one of their ids instead: %s", len(ids), key,
+			strings.Join(ids, ", "))
+	}
+}
+
+// parseMemo parses a memo template, and executes it with empty data so that unknown fields are found too.
+func parseMemo(memoTemplate string) (*template.Template, error) {
+	tmpl, err := template.New("memo").Parse(memoTemplate)
+	if err == nil {
+		err = tmpl.Execute(io.Discard, MemoData{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid memo template: %w", err)
+	}
+	return tmpl, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"nw-updater/crypto"
+	"nw-updater/institution"
+)
+
+// ValidateMain checks that every account_mappings value matches exactly one usable account in each destination,
+// printing a warning for each problem. Warnings don't cause an error, since accounts can be mapped for a
+// destination that isn't configured yet.
+func ValidateMain(config Config, decryptor crypto.OpenSslDecryptor) error {
+	ds, err := GetDestinations(config, decryptor)
+	if multi, ok := errors.AsType[*institution.MultiError](err); ok && len(ds) > 0 {
+		for _, e := range multi.Errors {
+			fmt.Printf("Skipping destination: %s\n", e)
+		}
+	} else if err != nil {
+		return err
+	}
+	if len(ds) == 0 {
+		return errors.New("no destinations are configured")
+	}
+	keys := make(map[string]bool)
+	for _, key := range config.AccountMappings {
+		keys[key] = true
+	}
+	warnings := 0
+	for _, d := range ds {
+		accounts, err := d.ListAccounts()
+		if err != nil {
+			return fmt.Errorf("error getting accounts from %s: %w", d.Name(), err)
+		}
+		for _, key := range slices.Sorted(maps.Keys(keys)) {
+			for _, warning := range validateMapping(accounts, key) {
+				fmt.Printf("%s: '%s' %s\n", d.Name(), key, warning)
+				warnings++
+			}
+		}
+	}
+	if warnings == 0 {
+		fmt.Printf("All %d mapped accounts are valid\n", len(keys))
+	} else {
+		fmt.Printf("%d warnings\n", warnings)
+	}
+	return nil
+}
+
+// validateMapping returns the problems with syncing balances to the account that key maps to.
+func validateMapping(accounts []DestinationAccount, key string) []string {
+	account, err := FindAccount(accounts, key)
+	if errors.Is(err, ErrAccountNotFound) {
+		return []string{"doesn't match any account id or name"}
+	} else if err != nil {
+		return []string{err.Error()}
+	}
+	var warnings []string
+	if account.Closed {
+		warnings = append(warnings, fmt.Sprintf("maps to closed account '%s'", account.Name))
+	}
+	if account.OnBudget {
+		warnings = append(warnings, fmt.Sprintf("maps to on-budget account '%s', adjustments will affect the budget",
+			account.Name))
+	}
+	if account.Id != key {
+		warnings = append(warnings, fmt.Sprintf("is matched by name, map to id %s so renaming the account doesn't "+
+			"break syncing", account.Id))
+	}
+	return warnings
+}
@@ -4,8 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"math"
-	. "nw-updater/common"
 	"slices"
+	"strings"
 	"time"
 
 	"github.com/brunomvsouza/ynab.go"
@@ -21,84 +21,218 @@ import (
 type YnabConfig struct {
 	EncryptedAccessToken string `yaml:"encrypted_access_token"`
 	BudgetName           string `yaml:"budget_name"`
+	// The payee and memo of adjustment transactions, see validateYnabTransactions
+	Transactions *TransactionConfig `yaml:"transactions,omitempty"`
 }
 
-// YnabUpdateBalances takes a map of YNAB account names to balances in cents as well as a config, and creates
-// adjustment transactions in those accounts to make the account balances match.
-func YnabUpdateBalances(balances map[string]AccountBalance, config YnabConfig, decryptor crypto.OpenSslDecryptor) error {
+// Ynab is used to interact with a single budget through the YNAB api.
+type Ynab struct {
+	client       ynab.ClientServicer
+	budgetId     string
+	transactions *TransactionConfig
+}
+
+// NewYnab creates a YNAB client and looks up the id of the budget in the config.
+func NewYnab(config YnabConfig, decryptor crypto.OpenSslDecryptor) (Ynab, error) {
+	err := validateYnabTransactions(config.Transactions)
+	if err != nil {
+		return Ynab{}, err
+	}
 	c := ynab.NewClient(decryptor.Decrypt(config.EncryptedAccessToken))
 	budgets, err := c.Budget().GetBudgets()
 	if err != nil {
-		return fmt.Errorf("unable to get budget: %w", err)
+		return Ynab{}, fmt.Errorf("unable to get budget: %w", err)
 	}
 	bIdx := slices.IndexFunc(budgets, func(summary *budget.Summary) bool {
 		return summary.Name == config.BudgetName
 	})
 	if bIdx == -1 {
-		return errors.New("unable to find budget")
+		return Ynab{}, errors.New("unable to find budget")
 	}
-	bId := budgets[bIdx].ID
-	results, err := c.Account().GetAccounts(bId, nil)
+	return Ynab{client: c, budgetId: budgets[bIdx].ID, transactions: config.Transactions}, nil
+}
+
+func init() {
+	registerDestination("ynab", func(config Config, d crypto.OpenSslDecryptor) (Destination, error) {
+		if config.YnabConfig == nil {
+			return nil, nil
+		}
+		return NewYnab(*config.YnabConfig, d)
+	})
+}
+
+// Name returns the name of YNAB as a Destination.
+func (y Ynab) Name() string {
+	return "YNAB"
+}
+
+// ListAccounts returns all accounts in the budget as destination accounts.
+func (y Ynab) ListAccounts() ([]DestinationAccount, error) {
+	results, err := y.client.Account().GetAccounts(y.budgetId, nil)
 	if err != nil {
-		return fmt.Errorf("unable to get accounts: %w", err)
+		return nil, fmt.Errorf("unable to get accounts: %w", err)
 	}
-	for accountName, accountBalance := range balances {
-		err := updateBalance(c, bId, accountName, accountBalance.Balance, results.Accounts)
-		if err != nil {
-			fmt.Printf("Unable to update balance for '%s': %v\n", accountName, err)
+	accounts := make([]DestinationAccount, len(results.Accounts))
+	for i, acct := range results.Accounts {
+		accounts[i] = DestinationAccount{Id: acct.ID, Name: acct.Name, Closed: acct.Closed || acct.Deleted,
+			OnBudget: acct.OnBudget}
+	}
+	return accounts, nil
+}
+
+// GetBalance validates a YNAB account and returns its balance in cents as of the end of date, leaving out any
+// fraction of a cent.
+func (y Ynab) GetBalance(da DestinationAccount, date time.Time) (int64, error) {
+	balance, err := y.GetBalanceMilliunits(da, date)
+	return balance / 10, err
+}
+
+// GetBalanceMilliunits validates a YNAB account and returns its balance in milliunits as of the end of date. YNAB
+// only reports the current balance, so transactions dated after date are subtracted from it.
+func (y Ynab) GetBalanceMilliunits(da DestinationAccount, date time.Time) (int64, error) {
+	acct, err := y.client.Account().GetAccount(y.budgetId, da.Id)
+	if err != nil {
+		return 0, fmt.Errorf("unable to get account: %w", err)
+	}
+	err = validateAccount(acct)
+	if err != nil {
+		return 0, err
+	}
+	year, month, day := date.Date()
+	since := time.Date(year, month, day+1, 0, 0, 0, 0, date.Location())
+	later, err := y.client.Transaction().GetTransactionsByAccount(y.budgetId, da.Id,
+		&transaction.Filter{Since: &api.Date{Time: since}})
+	if err != nil {
+		return 0, fmt.Errorf("unable to get transactions after %s: %w", date.Format(time.DateOnly), err)
+	}
+	balance := acct.Balance
+	for _, t := range later {
+		// the filter is inclusive of since, but check the date in case of time zone differences
+		if !t.Deleted && t.Date.Format(time.DateOnly) > date.Format(time.DateOnly) {
+			balance -= t.Amount
 		}
 	}
-	return nil
+	return balance, nil
 }
 
-// updateBalance Updates the balance in an individual YNAB account by creating an adjustment transaction
-func updateBalance(c ynab.ClientServicer, budgetId, accountName string, newBalance int64, accounts []*account.Account) error {
-	accountIdx := slices.IndexFunc(accounts, func(a *account.Account) bool {
-		return a.Name == accountName
-	})
-	if accountIdx == -1 {
-		return fmt.Errorf("unable to find account '%s'", accountName)
+// CreateAdjustment updates the balance in an individual YNAB account by creating an adjustment transaction
+// dated the day of the new balance.
+// In idempotent mode, an adjustment already created on the same account and date is updated instead, or deleted
+// if the amounts cancel out.
+func (y Ynab) CreateAdjustment(adjustment Adjustment) error {
+	var existing *transaction.Transaction
+	var err error
+	if y.transactions.IsIdempotent() {
+		existing, err = y.findAdjustment(adjustment.AccountId, adjustment.Date)
+		if err != nil {
+			return err
+		}
+	}
+	if existing != nil {
+		// the transaction replaces the earlier one, so its memo describes both changes
+		adjustment = adjustment.Replacing(existing.Amount)
 	}
-	acct := accounts[accountIdx]
-	err := validateAccount(acct, newBalance)
+	t, err := y.transactions.Transaction(adjustment)
 	if err != nil {
 		return err
 	}
-	payee := "Market Changes"
-	memo := "Entered automatically by nw-updater"
-	difference := (newBalance * 10) - acct.Balance
-	_, err = c.Transaction().CreateTransaction(budgetId, transaction.PayloadTransaction{
-		AccountID: acct.ID,
-		Date:      api.Date{Time: time.Now()},
-		Amount:    difference,
+	amount := adjustment.DifferenceMilliunits()
+	payload := transaction.PayloadTransaction{
+		AccountID: adjustment.AccountId,
+		Date:      api.Date{Time: adjustment.Date},
+		Amount:    amount,
 		Cleared:   transaction.ClearingStatusReconciled,
 		Approved:  true,
-		PayeeName: &payee,
-		Memo:      &memo,
-	})
+		PayeeName: &t.Payee,
+		Memo:      &t.Memo,
+	}
+	action := "Updated"
+	switch {
+	case existing == nil:
+		if y.transactions.IsIdempotent() {
+			// import ids stay reserved after a transaction is deleted, so the time keeps them unique
+			payload.ImportID = new(adjustmentImportPrefix(adjustment.Date) + time.Now().Format("150405"))
+		}
+		_, err = y.client.Transaction().CreateTransaction(y.budgetId, payload)
+	case amount == 0:
+		_, err = y.client.Transaction().DeleteTransaction(y.budgetId, existing.ID)
+		action = "Deleted today's adjustment, updated"
+	default:
+		payload.ImportID = existing.ImportID
+		_, err = y.client.Transaction().UpdateTransaction(y.budgetId, existing.ID, payload)
+		action = "Changed today's adjustment, updated"
+	}
 	if err != nil {
-		return fmt.Errorf("unable to create adjustment transaction on account '%s': %w", accountName, err)
+		return fmt.Errorf("unable to save adjustment transaction on account '%s': %w", adjustment.AccountName, err)
 	}
 	sign := "+"
-	if difference < 0 {
+	if amount < 0 {
 		sign = "-"
 	}
-	fmt.Printf("Updated '%s' to $%.2f ($%s%.2f)\n",
-		accountName, float64(newBalance)/100.0, sign, math.Abs(float64(difference))/1000.0)
+	fmt.Printf("%s '%s' to $%.2f as of %s ($%s%.2f)\n", action, adjustment.AccountName,
+		float64(adjustment.New)/100.0, adjustment.Date.Format(time.DateOnly), sign, math.Abs(float64(amount))/1000.0)
+	return nil
+}
+
+// adjustmentImportPrefix returns the start of the import id of adjustments created in idempotent mode on date.
+func adjustmentImportPrefix(date time.Time) string {
+	return "nw-updater:" + date.Format(time.DateOnly) + ":"
+}
+
+// findAdjustment returns the adjustment created in idempotent mode on an account and date, or nil if there isn't one.
+func (y Ynab) findAdjustment(accountId string, date time.Time) (*transaction.Transaction, error) {
+	transactions, err := y.client.Transaction().GetTransactionsByAccount(y.budgetId, accountId,
+		&transaction.Filter{Since: &api.Date{Time: date}})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get transactions: %w", err)
+	}
+	prefix := adjustmentImportPrefix(date)
+	for _, t := range transactions {
+		if !t.Deleted && t.ImportID != nil && strings.HasPrefix(*t.ImportID, prefix) {
+			return t, nil
+		}
+	}
+	return nil, nil
+}
+
+// validateYnabTransactions checks the transactions config for options YNAB adjustments can't use. Adjustments are
+// only made in tracking accounts with no uncleared transactions (see validateAccount), so they are always
+// reconciled, since an uncleared one would stop the account being updated again, and can't have a category.
+func validateYnabTransactions(c *TransactionConfig) error {
+	err := c.Validate()
+	if err != nil || c == nil {
+		return err
+	}
+	check := func(options TransactionOptions) error {
+		if options.Cleared != nil && !*options.Cleared {
+			return errors.New("cleared: false isn't supported, the account couldn't be updated again until the " +
+				"adjustment is cleared")
+		}
+		if options.Category != nil {
+			return errors.New("category isn't supported, adjustments are only made in tracking accounts which " +
+				"can't have categories")
+		}
+		return nil
+	}
+	if err = check(c.TransactionOptions); err != nil {
+		return fmt.Errorf("ynab transactions: %w", err)
+	}
+	for account, options := range c.Accounts {
+		if err = check(options); err != nil {
+			return fmt.Errorf("ynab transactions account '%s': %w", account, err)
+		}
+	}
 	return nil
 }
 
 // validateAccount checks that an account: is on budget, not deleted or closed,
-// is an "other asset" account, is up-to-date with reconciliation, and has a balance that has changed.
-func validateAccount(acct *account.Account, newBalance int64) error {
+// is an "other asset" account, and is up-to-date with reconciliation.
+func validateAccount(acct *account.Account) error {
 	if acct.OnBudget || acct.Deleted || acct.Closed || acct.Type != account.TypeOtherAsset {
 		return fmt.Errorf("account does not pass checks: %+v", acct)
 	}
 	if acct.ClearedBalance != acct.Balance || acct.UnclearedBalance > 0 {
 		return fmt.Errorf("account has an uncleared balance: %+v", acct)
 	}
-	if acct.Balance == newBalance*10 {
-		return errors.New("account balance has not changed")
-	}
 	return nil
 }